@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// authConfig is the yaml file of clients allowed to use the server.
+	authConfig struct {
+		Clients []authClient `yaml:"clients"`
+	}
+	// authClient is identified by it's token, sent as a bearer token or in
+	// the X-API-Key header. Empty Providers or Endpoints allows all, and a
+	// Quota of 0 is unlimited requests per day.
+	authClient struct {
+		Name      string   `yaml:"name"`
+		Token     string   `yaml:"token"`
+		Providers []string `yaml:"providers"`
+		Endpoints []string `yaml:"endpoints"`
+		Quota     int      `yaml:"quota"`
+	}
+	// authenticator checks requests against the clients, and counts their
+	// requests for the daily quota.
+	authenticator struct {
+		sync.Mutex
+		clients []authClient
+		day     string
+		used    map[string]int
+	}
+	clientKey struct{}
+)
+
+// loadAuth reads the clients from the auth file.
+func loadAuth(path string) (*authenticator, error) {
+	b, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg authConfig
+
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for i, c := range cfg.Clients {
+		if len(c.Token) == 0 {
+			return nil, fmt.Errorf("%s: client %d %q has no token", path, i, c.Name)
+		}
+		if len(c.Name) == 0 {
+			cfg.Clients[i].Name = fmt.Sprintf("client-%d", i)
+		}
+	}
+
+	return &authenticator{clients: cfg.Clients, used: map[string]int{}}, nil
+}
+
+// Handler is the alice middleware, which rejects requests without a known
+// token with 401, and requests outside the client's providers, endpoints
+// or quota with 403.
+func (a *authenticator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if public(req.URL.Path) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		c, ok := a.client(token(req))
+
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gogeo"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized: missing or unknown token")
+			return
+		}
+
+		provider, endpoint := routeOf(req.URL.Path)
+
+		if len(provider) > 0 && !allowed(c.Providers, provider) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("forbidden: %s can't use %s", c.Name, provider))
+			return
+		}
+
+		if len(endpoint) > 0 && !allowed(c.Endpoints, endpoint) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("forbidden: %s can't use /%s", c.Name, endpoint))
+			return
+		}
+
+		if !a.count(c) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("forbidden: %s has used it's quota of %d requests today", c.Name, c.Quota))
+			return
+		}
+
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), clientKey{}, c.Name)))
+	})
+}
+
+func (a *authenticator) client(token string) (authClient, bool) {
+	if len(token) == 0 {
+		return authClient{}, false
+	}
+
+	for _, c := range a.clients {
+		if subtle.ConstantTimeCompare([]byte(c.Token), []byte(token)) == 1 {
+			return c, true
+		}
+	}
+
+	return authClient{}, false
+}
+
+// count records a request, and reports whether it's within the quota.
+// The quota resets at midnight UTC.
+func (a *authenticator) count(c authClient) bool {
+	a.Lock()
+	defer a.Unlock()
+
+	if day := time.Now().UTC().Format("2006-01-02"); day != a.day {
+		a.day, a.used = day, map[string]int{}
+	}
+
+	if c.Quota > 0 && a.used[c.Name] >= c.Quota {
+		return false
+	}
+
+	a.used[c.Name]++
+	return true
+}
+
+// public reports whether path is open without a token, and without rate
+// limits, so probes can reach the health checks and browsers the docs.
+func public(path string) bool {
+	switch path {
+	case "/healthz", "/readyz", "/openapi.json", "/docs":
+		return true
+	}
+
+	return false
+}
+
+// token returns the bearer token or the X-API-Key header.
+func token(req *http.Request) string {
+	if h := req.Header.Get("Authorization"); len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+
+	return req.Header.Get("X-API-Key")
+}
+
+// clientName returns the authenticated client of the request, if any.
+func clientName(req *http.Request) string {
+	name, _ := req.Context().Value(clientKey{}).(string)
+	return name
+}
+
+// routeOf splits /:name/:format into the provider and the endpoint, which
+// is the format, or "template" for the template routes.
+func routeOf(path string) (provider, endpoint string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	if len(parts) < 2 {
+		return "", ""
+	}
+
+	return strings.ToLower(parts[0]), strings.ToLower(parts[1])
+}
+
+func allowed(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+
+	for _, l := range list {
+		if strings.EqualFold(l, v) {
+			return true
+		}
+	}
+
+	return false
+}
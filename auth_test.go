@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const authYAML = `
+clients:
+  - name: maps
+    token: maps-token-1234
+    providers: [local]
+    endpoints: [json, png]
+    quota: 2
+  - name: admin
+    token: admin-token-5678
+`
+
+func TestAuthHandler(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gogeo")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "auth.yml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(authYAML), 0600))
+
+	auth, err := loadAuth(path)
+	assert.Nil(t, err)
+
+	var client string
+	h := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		client = clientName(req)
+	}))
+
+	serve := func(path, header, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		if len(header) > 0 {
+			req.Header.Set(header, token)
+		}
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/local/json", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `{"error":{"status":401,"message":"unauthorized: missing or unknown token"}}`, w.Body.String())
+
+	assert.Equal(t, http.StatusUnauthorized, serve("/local/json", "Authorization", "Bearer wrong").Code)
+	assert.Equal(t, http.StatusForbidden, serve("/google/json", "Authorization", "Bearer maps-token-1234").Code)
+	assert.Equal(t, http.StatusForbidden, serve("/local/xml", "X-API-Key", "maps-token-1234").Code)
+
+	assert.Equal(t, http.StatusOK, serve("/local/json", "Authorization", "Bearer maps-token-1234").Code)
+	assert.Equal(t, "maps", client)
+	assert.Equal(t, http.StatusOK, serve("/LOCAL/png", "X-API-Key", "maps-token-1234").Code)
+
+	// the quota of 2 requests is used
+	assert.Equal(t, http.StatusForbidden, serve("/local/json", "X-API-Key", "maps-token-1234").Code)
+	assert.Equal(t, http.StatusOK, serve("/google/xml", "X-API-Key", "admin-token-5678").Code)
+	assert.Equal(t, "admin", client)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAge is the Cache-Control max-age of the geocoding, template and image
+// routes. 0 lets clients cache the response, but they must revalidate it
+// with the ETag.
+type maxAge struct {
+	Geo      time.Duration
+	Template time.Duration
+	Image    time.Duration
+}
+
+// writeCached writes the body with a strong ETag and the Cache-Control of
+// age, or 304 Not Modified if the If-None-Match header matches the ETag.
+// A negative age is not cached at all.
+func writeCached(w http.ResponseWriter, req *http.Request, age time.Duration, contentType string, b []byte) {
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Cache-Control", cacheControl(req, age))
+
+	if age < 0 {
+		w.Write(b)
+		return
+	}
+
+	sum := sha256.Sum256(b)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	h.Set("ETag", etag)
+
+	if etagMatch(req.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Write(b)
+}
+
+// cacheControl returns the Cache-Control header of age. Responses are
+// private to the client, when they depend on it's token or key.
+func cacheControl(req *http.Request, age time.Duration) string {
+	if age < 0 {
+		return "no-store"
+	}
+
+	v := "public, "
+
+	if len(server.Auth) > 0 || len(req.URL.Query().Get("key")) > 0 {
+		v = "private, "
+	}
+
+	if age == 0 {
+		return v + "no-cache"
+	}
+
+	return v + "max-age=" + strconv.FormatInt(int64(age/time.Second), 10)
+}
+
+// etagMatch reports whether the If-None-Match header matches etag, using
+// the weak comparison of RFC 7232.
+func etagMatch(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+func TestCaching(t *testing.T) {
+	local, _ := geo.New("local")
+	providers = map[string]geo.Provider{"local": local}
+	server.MaxAge.Geo = time.Hour
+	defer func() { server.MaxAge, server.Auth = maxAge{}, "" }()
+
+	ps := httprouter.Params{{Key: "name", Value: "local"}}
+	get := func(url, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		if len(etag) > 0 {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		geoHandler(w, req, ps)
+		return w
+	}
+
+	w := get("/local/json?loc=55.676,12.568", "")
+	etag := w.Header().Get("ETag")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "public, max-age=3600", w.Header().Get("Cache-Control"))
+	assert.Empty(t, w.Header().Get("Vary"))
+	assert.Len(t, etag, 34)
+
+	// the same results has the same etag
+	assert.Equal(t, etag, get("/local/json?loc=55.676,12.568", "").Header().Get("ETag"))
+	assert.NotEqual(t, etag, get("/local/xml?loc=55.676,12.568", "").Header().Get("ETag"))
+
+	w = get("/local/json?loc=55.676,12.568", `"other", W/`+etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.Empty(t, w.Body.String())
+
+	// failed lookups aren't cached
+	w = get("/local/json?addr=valby", "")
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.Empty(t, w.Header().Get("ETag"))
+
+	w = get("/local/ndjson?loc=55.676,12.568", "")
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	server.Auth, server.MaxAge.Geo = "clients.yml", 0
+	w = get("/local/json?loc=55.676,12.568", etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, "private, no-cache", w.Header().Get("Cache-Control"))
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+type (
+	// compression compresses responses of at least MinSize bytes with
+	// brotli, gzip or deflate, at Level 1-9. Level 0 disables it.
+	compression struct {
+		Level   int
+		MinSize int
+	}
+	// compressWriter buffers the response until it's MinSize bytes, or
+	// flushed, before it decides whether to compress it.
+	compressWriter struct {
+		http.ResponseWriter
+		c        *compression
+		encoding string
+		status   int
+		buf      []byte
+		started  bool
+		enc      compressor
+	}
+	compressor interface {
+		io.WriteCloser
+		Flush() error
+	}
+)
+
+// encodings are the supported content encodings, in order of preference.
+var encodings = []string{"br", "gzip", "deflate"}
+
+// Enabled reports whether responses are compressed.
+func (c *compression) Enabled() bool {
+	return c.Level > 0
+}
+
+// Handler is the alice middleware, which compresses the response in the
+// encoding of the Accept-Encoding header. Images, which are compressed
+// already, and responses below MinSize are sent as is. Flushed responses,
+// like ndjson, are compressed as they are streamed.
+func (c *compression) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptEncoding(req.Header.Get("Accept-Encoding"))
+
+		if len(encoding) == 0 || req.Method == "HEAD" {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, c: c, encoding: encoding}
+		defer cw.Close()
+
+		next.ServeHTTP(cw, req)
+	})
+}
+
+// acceptEncoding returns the preferred encoding of the Accept-Encoding
+// header, or "" if the response shouldn't be compressed.
+func acceptEncoding(header string) string {
+	best, bestQ := "", 0.0
+
+	for _, e := range encodings {
+		q, specificity := 0.0, 0
+
+		for _, r := range strings.Split(header, ",") {
+			params := strings.Split(r, ";")
+			name := strings.ToLower(strings.TrimSpace(params[0]))
+			s := 0
+
+			if name == e {
+				s = 2
+			} else if name == "*" {
+				s = 1
+			}
+
+			if s <= specificity {
+				continue
+			}
+
+			specificity, q = s, 1
+
+			for _, p := range params[1:] {
+				if kv := strings.SplitN(strings.TrimSpace(p), "=", 2); len(kv) == 2 && strings.EqualFold(kv[0], "q") {
+					if q, _ = strconv.ParseFloat(kv[1], 64); q < 0 {
+						q = 0
+					}
+				}
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+
+	return best
+}
+
+// compressible reports whether a response with the header and status
+// should be compressed.
+func compressible(h http.Header, status int) bool {
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+
+	if len(h.Get("Content-Encoding")) > 0 {
+		return false
+	}
+
+	t := strings.ToLower(h.Get("Content-Type"))
+
+	// png, jpeg, gif and webp are compressed already, svg is text.
+	if strings.HasPrefix(t, "image/") && !strings.Contains(t, "+xml") {
+		return false
+	}
+
+	return len(t) > 0
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.started {
+		if w.enc != nil {
+			return w.enc.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+
+	if len(w.buf) >= w.c.MinSize {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// start writes the header, compressed if compress is true and the
+// response is compressible, and the buffered body.
+func (w *compressWriter) start(compress bool) error {
+	w.started = true
+	h := w.Header()
+
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	if compress && compressible(h, w.status) {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+
+		// the compressed body isn't byte for byte the same as the strong
+		// etag, but it's still equal to it with a weak comparison.
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", "W/"+etag)
+		}
+
+		switch w.encoding {
+		case "br":
+			w.enc = brotli.NewWriterLevel(w.ResponseWriter, w.c.Level)
+		case "gzip":
+			w.enc, _ = gzip.NewWriterLevel(w.ResponseWriter, w.c.Level)
+		default:
+			w.enc, _ = flate.NewWriter(w.ResponseWriter, w.c.Level)
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	var err error
+
+	if w.enc != nil {
+		_, err = w.enc.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+
+	w.buf = nil
+	return err
+}
+
+// Flush starts compressing a streamed response, regardless of it's size,
+// and flushes what's compressed so far to the client.
+func (w *compressWriter) Flush() {
+	if !w.started {
+		w.start(true)
+	}
+
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close writes responses below the minimum size as is, and finishes the
+// compressed stream.
+func (w *compressWriter) Close() error {
+	if !w.started {
+		return w.start(false)
+	}
+
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+
+	return nil
+}
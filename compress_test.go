@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+func TestAcceptEncoding(t *testing.T) {
+	for header, expected := range map[string]string{
+		"":                         "",
+		"identity":                 "",
+		"gzip":                     "gzip",
+		"deflate, gzip":            "gzip",
+		"gzip;q=0.5, deflate":      "deflate",
+		"br, gzip;q=0":             "br",
+		"gzip, deflate, br":        "br",
+		"br;q=0.5, gzip":           "gzip",
+		"compress, gzip;q=0":       "",
+		"*":                        "br",
+		"*;q=0.1, deflate;q=0.5":   "deflate",
+		"GZIP;q=1.0, identity;q=0": "gzip",
+	} {
+		assert.Equal(t, expected, acceptEncoding(header), header)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	c := &compression{Level: 6, MinSize: 100}
+	body := strings.Repeat(`{"query":"valby"},`, 100)
+
+	serve := func(contentType, body, encoding string) *httptest.ResponseRecorder {
+		h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("ETag", `"abc"`)
+			w.Write([]byte(body))
+		}))
+		req := httptest.NewRequest("GET", "/google/json", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("application/json", body, "gzip, deflate")
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Equal(t, `W/"abc"`, w.Header().Get("ETag"))
+	assert.True(t, w.Body.Len() < len(body))
+
+	r, err := gzip.NewReader(w.Body)
+	assert.Nil(t, err)
+	b, _ := ioutil.ReadAll(r)
+	assert.Equal(t, body, string(b))
+
+	w = serve("application/xml", body, "deflate")
+	assert.Equal(t, "deflate", w.Header().Get("Content-Encoding"))
+	b, _ = ioutil.ReadAll(flate.NewReader(w.Body))
+	assert.Equal(t, body, string(b))
+
+	// below the minimum size
+	w = serve("application/json", "[]", "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, `"abc"`, w.Header().Get("ETag"))
+	assert.Equal(t, "[]", w.Body.String())
+
+	w = serve("image/png", body, "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, body, w.Body.String())
+
+	w = serve("application/json", body, "gzip, br")
+	assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+	b, _ = ioutil.ReadAll(brotli.NewReader(w.Body))
+	assert.Equal(t, body, string(b))
+
+	w = serve("application/json", body, "compress")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, body, w.Body.String())
+}
+
+func TestCompressionStream(t *testing.T) {
+	local, _ := geo.New("local")
+	providers = map[string]geo.Provider{"local": local}
+
+	h := (&compression{Level: 6, MinSize: 1 << 20}).Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		geoHandler(w, req, httprouter.Params{{Key: "name", Value: "local"}})
+	}))
+
+	// a streamed response is compressed, even if it's below the minimum size
+	req := httptest.NewRequest("GET", "/local/ndjson?loc=55.676,12.568&loc=56.162,10.203", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.True(t, w.Flushed)
+
+	r, err := gzip.NewReader(w.Body)
+	assert.Nil(t, err)
+
+	var lines []string
+	for s := bufio.NewScanner(r); s.Scan(); {
+		lines = append(lines, s.Text())
+	}
+
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[1], "56.162")
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	"github.com/harboe/gogeo/geo"
+	"github.com/harboe/gogeo/geo/middleware"
+)
+
+type (
+	// fileConfig is the yaml config file, ~/.config/gogeo/config.yml unless
+	// another is given with --config.
+	fileConfig struct {
+		Default string `yaml:"default"`
+		Format  string `yaml:"format"`
+		Tiles   string `yaml:"tiles"`
+		Trace   string `yaml:"trace"`
+		Log     struct {
+			Level  string `yaml:"level"`
+			Format string `yaml:"format"`
+			Output string `yaml:"output"`
+		} `yaml:"log"`
+		Providers map[string]providerConfig `yaml:"providers"`
+		Cache     struct {
+			TTL  string `yaml:"ttl"`
+			Size string `yaml:"size"`
+		} `yaml:"cache"`
+		Breaker struct {
+			Threshold string `yaml:"threshold"`
+			Cooldown  string `yaml:"cooldown"`
+		} `yaml:"breaker"`
+		RateLimit struct {
+			RPS   string `yaml:"rps"`
+			Burst string `yaml:"burst"`
+		} `yaml:"ratelimit"`
+		Server struct {
+			Port      string `yaml:"port"`
+			Templates string `yaml:"templates"`
+			AllowKey  string `yaml:"allow_key"`
+			Auth      string `yaml:"auth"`
+			Socket    string `yaml:"socket"`
+			TLSCert   string `yaml:"tls_cert"`
+			TLSKey    string `yaml:"tls_key"`
+			Timeouts  struct {
+				Read     string `yaml:"read"`
+				Write    string `yaml:"write"`
+				Idle     string `yaml:"idle"`
+				Shutdown string `yaml:"shutdown"`
+			} `yaml:"timeouts"`
+			MaxAge struct {
+				Geo      string `yaml:"geo"`
+				Template string `yaml:"template"`
+				Image    string `yaml:"image"`
+			} `yaml:"max_age"`
+			CORS struct {
+				Origins     []string `yaml:"origins"`
+				Methods     []string `yaml:"methods"`
+				Headers     []string `yaml:"headers"`
+				Credentials string   `yaml:"credentials"`
+				MaxAge      string   `yaml:"max_age"`
+			} `yaml:"cors"`
+			Compress struct {
+				Level   string `yaml:"level"`
+				MinSize string `yaml:"min_size"`
+			} `yaml:"compress"`
+			Limit struct {
+				RPS   string `yaml:"rps"`
+				Burst string `yaml:"burst"`
+				Daily string `yaml:"daily"`
+			} `yaml:"limit"`
+		} `yaml:"server"`
+	}
+	providerConfig struct {
+		Key    string `yaml:"key"`
+		GeoURL string `yaml:"geo_url"`
+		ImgURL string `yaml:"img_url"`
+	}
+	// setting is an effective configuration value, and where it came from.
+	setting struct {
+		Name   string
+		Value  string
+		Source string
+	}
+)
+
+// Redacted returns the value with keys masked, for display.
+func (s setting) Redacted() string {
+	switch {
+	case strings.HasSuffix(s.Name, ".key"):
+		return geo.Redact(s.Value)
+	case strings.HasSuffix(s.Name, "_url"):
+		return geo.RedactURL(s.Value)
+	}
+
+	return s.Value
+}
+
+// configArg finds --config before the flags are parsed, the config file
+// is needed to pick the default provider command.
+func configArg(args []string) string {
+	for i, a := range args {
+		switch {
+		case a == "--":
+			return ""
+		case a == "--config" && i+1 < len(args):
+			return args[i+1]
+		case strings.HasPrefix(a, "--config="):
+			return strings.TrimPrefix(a, "--config=")
+		}
+	}
+
+	return ""
+}
+
+// defaultConfigFile is $XDG_CONFIG_HOME/gogeo/config.yml, or
+// ~/.config/gogeo/config.yml
+func defaultConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+
+	if len(dir) == 0 {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+
+	return filepath.Join(dir, "gogeo", "config.yml")
+}
+
+// loadConfig reads the config file, a missing default config file is
+// the same as an empty one.
+func loadConfig(path string) (cfg fileConfig, err error) {
+	explicit := len(path) > 0
+
+	if !explicit {
+		path = defaultConfigFile()
+	}
+
+	b, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) && !explicit {
+		return cfg, nil
+	} else if err != nil {
+		return cfg, err
+	}
+
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return cfg, nil
+}
+
+// resolve works out the effective settings for cmd, a flag wins over an
+// env variable, which wins over the config file.
+func (c *configFlags) resolve(cmd *cobra.Command) {
+	c.settings = nil
+
+	add := func(name, flag, env, file, def string) {
+		s := setting{Name: name, Value: def, Source: "default"}
+
+		if f := cmd.Flags().Lookup(flag); len(flag) > 0 && f != nil && f.Changed {
+			s.Value, s.Source = f.Value.String(), "flag --"+flag
+		} else if v := os.Getenv(env); len(env) > 0 && len(v) > 0 {
+			s.Value, s.Source = v, "env "+env
+		} else if len(file) > 0 {
+			s.Value, s.Source = file, "config"
+		}
+
+		c.settings = append(c.settings, s)
+	}
+
+	for _, p := range geo.Providers() {
+		env := "GOGEO_" + strings.ToUpper(p)
+		keyFlag := p + "-key"
+
+		if isProvider(cmd, p) {
+			keyFlag = "key"
+		}
+
+		add(p+".key", keyFlag, env, c.file.Providers[p].Key, "")
+		add(p+".geo_url", "", env+"_GEO_URL", c.file.Providers[p].GeoURL, "")
+		add(p+".img_url", "", env+"_IMG_URL", c.file.Providers[p].ImgURL, "")
+	}
+
+	add("tiles", "tiles", "GOGEO_TILES", c.file.Tiles, "")
+	add("trace", "trace", "GOGEO_TRACE", c.file.Trace, "")
+	add("log.level", "log-level", "GOGEO_LOG_LEVEL", c.file.Log.Level, "info")
+	add("log.format", "log-format", "GOGEO_LOG_FORMAT", c.file.Log.Format, "text")
+	add("log.output", "log-output", "GOGEO_LOG_OUTPUT", c.file.Log.Output, "stderr")
+	add("default", "", "GOGEO_DEFAULT_PROVIDER", c.file.Default, "")
+	add("format", "", "GOGEO_FORMAT", c.file.Format, "json")
+	add("cache.ttl", "", "GOGEO_CACHE_TTL", c.file.Cache.TTL, "0s")
+	add("cache.size", "", "GOGEO_CACHE_SIZE", c.file.Cache.Size, "1000")
+	add("ratelimit.rps", "", "GOGEO_RATELIMIT_RPS", c.file.RateLimit.RPS, "0")
+	add("ratelimit.burst", "", "GOGEO_RATELIMIT_BURST", c.file.RateLimit.Burst, "1")
+	add("breaker.threshold", "", "GOGEO_BREAKER_THRESHOLD", c.file.Breaker.Threshold, "5")
+	add("breaker.cooldown", "", "GOGEO_BREAKER_COOLDOWN", c.file.Breaker.Cooldown, "30s")
+	add("server.port", "port", "GOGEO_PORT", c.file.Server.Port, "localhost:8080")
+	add("server.templates", "templates", "GOGEO_TEMPLATES", c.file.Server.Templates, "")
+	add("server.allow_key", "allow-key", "GOGEO_ALLOW_KEY", c.file.Server.AllowKey, "false")
+	add("server.auth", "auth", "GOGEO_AUTH", c.file.Server.Auth, "")
+	add("server.socket", "socket", "GOGEO_SOCKET", c.file.Server.Socket, "")
+	add("server.tls_cert", "tls-cert", "GOGEO_TLS_CERT", c.file.Server.TLSCert, "")
+	add("server.tls_key", "tls-key", "GOGEO_TLS_KEY", c.file.Server.TLSKey, "")
+	add("server.timeouts.read", "read-timeout", "GOGEO_READ_TIMEOUT", c.file.Server.Timeouts.Read, "15s")
+	add("server.timeouts.write", "write-timeout", "GOGEO_WRITE_TIMEOUT", c.file.Server.Timeouts.Write, "5m0s")
+	add("server.timeouts.idle", "idle-timeout", "GOGEO_IDLE_TIMEOUT", c.file.Server.Timeouts.Idle, "2m0s")
+	add("server.timeouts.shutdown", "shutdown-timeout", "GOGEO_SHUTDOWN_TIMEOUT", c.file.Server.Timeouts.Shutdown, "30s")
+	add("server.max_age.geo", "max-age-geo", "GOGEO_MAX_AGE_GEO", c.file.Server.MaxAge.Geo, "0s")
+	add("server.max_age.template", "max-age-template", "GOGEO_MAX_AGE_TEMPLATE", c.file.Server.MaxAge.Template, "0s")
+	add("server.max_age.image", "max-age-image", "GOGEO_MAX_AGE_IMAGE", c.file.Server.MaxAge.Image, "0s")
+	add("server.cors.origins", "cors-origins", "GOGEO_CORS_ORIGINS", strings.Join(c.file.Server.CORS.Origins, ", "), "*")
+	add("server.cors.methods", "cors-methods", "GOGEO_CORS_METHODS", strings.Join(c.file.Server.CORS.Methods, ", "), "GET, HEAD, OPTIONS")
+	add("server.cors.headers", "cors-headers", "GOGEO_CORS_HEADERS", strings.Join(c.file.Server.CORS.Headers, ", "), corsHeaders)
+	add("server.cors.credentials", "cors-credentials", "GOGEO_CORS_CREDENTIALS", c.file.Server.CORS.Credentials, "false")
+	add("server.cors.max_age", "cors-max-age", "GOGEO_CORS_MAX_AGE", c.file.Server.CORS.MaxAge, "10m0s")
+	add("server.compress.level", "compress-level", "GOGEO_COMPRESS_LEVEL", c.file.Server.Compress.Level, "6")
+	add("server.compress.min_size", "compress-min-size", "GOGEO_COMPRESS_MIN_SIZE", c.file.Server.Compress.MinSize, "1024")
+	add("server.limit.rps", "limit-rps", "GOGEO_LIMIT_RPS", c.file.Server.Limit.RPS, "0")
+	add("server.limit.burst", "limit-burst", "GOGEO_LIMIT_BURST", c.file.Server.Limit.Burst, "10")
+	add("server.limit.daily", "limit-daily", "GOGEO_LIMIT_DAILY", c.file.Server.Limit.Daily, "0")
+}
+
+// apply resolves the settings for cmd and sets the flag values, which
+// weren't given on the command line. It runs before every command.
+func (c *configFlags) apply(cmd *cobra.Command, args []string) error {
+	c.resolve(cmd)
+
+	if p := c.Get("default"); len(p) > 0 && !isProvider(nil, p) {
+		return fmt.Errorf("config: unknown default provider %q", p)
+	}
+
+	if _, ok := encoders[c.Get("format")]; !ok {
+		return fmt.Errorf("config: unknown format %q", c.Get("format"))
+	}
+
+	if err := c.buildFetchers(); err != nil {
+		return err
+	}
+
+	allowKey, err := strconv.ParseBool(c.Get("server.allow_key"))
+
+	if err != nil {
+		return fmt.Errorf("config: server.allow_key: %v", err)
+	}
+
+	if server.Limit.Rate, err = strconv.ParseFloat(c.Get("server.limit.rps"), 64); err != nil {
+		return fmt.Errorf("config: server.limit.rps: %v", err)
+	}
+
+	if server.Limit.Burst, err = strconv.Atoi(c.Get("server.limit.burst")); err != nil || server.Limit.Burst < 1 {
+		return fmt.Errorf("config: server.limit.burst: must be a positive number")
+	}
+
+	if server.Limit.Daily, err = strconv.Atoi(c.Get("server.limit.daily")); err != nil {
+		return fmt.Errorf("config: server.limit.daily: %v", err)
+	}
+
+	timeouts := map[string]*time.Duration{
+		"read":     &server.ReadTimeout,
+		"write":    &server.WriteTimeout,
+		"idle":     &server.IdleTimeout,
+		"shutdown": &server.ShutdownTimeout,
+	}
+
+	for name, d := range timeouts {
+		if *d, err = time.ParseDuration(c.Get("server.timeouts." + name)); err != nil {
+			return fmt.Errorf("config: server.timeouts.%s: %v", name, err)
+		}
+	}
+
+	ages := map[string]*time.Duration{
+		"geo":      &server.MaxAge.Geo,
+		"template": &server.MaxAge.Template,
+		"image":    &server.MaxAge.Image,
+	}
+
+	for name, d := range ages {
+		if *d, err = time.ParseDuration(c.Get("server.max_age." + name)); err != nil || *d < 0 {
+			return fmt.Errorf("config: server.max_age.%s: must be a duration of 0s or more", name)
+		}
+	}
+
+	cors := corsPolicy{
+		Origins: splitList(c.Get("server.cors.origins")),
+		Methods: splitList(c.Get("server.cors.methods")),
+		Headers: splitList(c.Get("server.cors.headers")),
+	}
+
+	if cors.Credentials, err = strconv.ParseBool(c.Get("server.cors.credentials")); err != nil {
+		return fmt.Errorf("config: server.cors.credentials: %v", err)
+	}
+
+	if cors.MaxAge, err = time.ParseDuration(c.Get("server.cors.max_age")); err != nil {
+		return fmt.Errorf("config: server.cors.max_age: %v", err)
+	}
+
+	if err := cors.validate(); err != nil {
+		return fmt.Errorf("config: server.cors.%v", err)
+	}
+
+	if server.Compress.Level, err = strconv.Atoi(c.Get("server.compress.level")); err != nil || server.Compress.Level < 0 || server.Compress.Level > 9 {
+		return fmt.Errorf("config: server.compress.level: must be 1-9, or 0 to disable compression")
+	}
+
+	if server.Compress.MinSize, err = strconv.Atoi(c.Get("server.compress.min_size")); err != nil || server.Compress.MinSize < 0 {
+		return fmt.Errorf("config: server.compress.min_size: must be 0 or more bytes")
+	}
+
+	if (len(c.Get("server.tls_cert")) > 0) != (len(c.Get("server.tls_key")) > 0) {
+		return fmt.Errorf("config: server.tls_cert and server.tls_key must be given together")
+	}
+
+	if err := c.startTracer(); err != nil {
+		return err
+	}
+
+	if err := c.startLog(); err != nil {
+		return err
+	}
+
+	c.Tiles = c.Get("tiles")
+	format.Default = c.Get("format")
+	server.Port = c.Get("server.port")
+	server.Templates = c.Get("server.templates")
+	server.AllowKey = allowKey
+	server.Auth = c.Get("server.auth")
+	server.Socket = c.Get("server.socket")
+	server.TLSCert = c.Get("server.tls_cert")
+	server.TLSKey = c.Get("server.tls_key")
+	server.CORS = cors
+
+	return nil
+}
+
+// buildFetchers builds a fetcher for each provider, with it's own cache,
+// rate limit and circuit breaker.
+func (c *configFlags) buildFetchers() error {
+	ttl, err := time.ParseDuration(c.Get("cache.ttl"))
+
+	if err != nil {
+		return fmt.Errorf("config: cache.ttl: %v", err)
+	}
+
+	size, err := strconv.Atoi(c.Get("cache.size"))
+
+	if err != nil {
+		return fmt.Errorf("config: cache.size: %v", err)
+	}
+
+	rps, err := strconv.ParseFloat(c.Get("ratelimit.rps"), 64)
+
+	if err != nil {
+		return fmt.Errorf("config: ratelimit.rps: %v", err)
+	}
+
+	burst, err := strconv.Atoi(c.Get("ratelimit.burst"))
+
+	if err != nil {
+		return fmt.Errorf("config: ratelimit.burst: %v", err)
+	}
+
+	threshold, err := strconv.Atoi(c.Get("breaker.threshold"))
+
+	if err != nil {
+		return fmt.Errorf("config: breaker.threshold: %v", err)
+	}
+
+	cooldown, err := time.ParseDuration(c.Get("breaker.cooldown"))
+
+	if err != nil {
+		return fmt.Errorf("config: breaker.cooldown: %v", err)
+	}
+
+	c.fetchers = map[string]geo.Fetcher{}
+	c.breakers = map[string]*middleware.Breaker{}
+
+	for _, p := range geo.Providers() {
+		var f geo.Fetcher = http.DefaultClient
+
+		if rps > 0 {
+			f = middleware.RateLimit(f, rps, burst)
+		}
+
+		c.breakers[p] = middleware.NewBreaker(threshold, cooldown)
+		f = c.breakers[p].Fetcher(f)
+
+		if ttl > 0 {
+			f = middleware.Cache(f, ttl, size)
+		}
+
+		c.fetchers[p] = middleware.Tracing(middleware.Logger(middleware.Metrics(f, p), p), p)
+	}
+
+	return nil
+}
+
+// startTracer exports the spans to stderr with "-", so they don't mix with
+// the results on stdout, or appends them to the trace file.
+func (c configFlags) startTracer() error {
+	switch path := c.Get("trace"); path {
+	case "":
+		middleware.DefaultTracer = nil
+	case "-":
+		middleware.DefaultTracer = middleware.NewTracer(os.Stderr)
+	default:
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+		if err != nil {
+			return fmt.Errorf("config: trace: %v", err)
+		}
+
+		middleware.DefaultTracer = middleware.NewTracer(f)
+	}
+
+	return nil
+}
+
+// startLog sets up the log, --verbose logs every provider request.
+func (c configFlags) startLog() error {
+	level, err := middleware.ParseLevel(c.Get("log.level"))
+
+	if err != nil {
+		return fmt.Errorf("config: log.level: %v", err)
+	}
+
+	if c.Verbose {
+		level = middleware.LevelDebug
+	}
+
+	var json bool
+
+	switch f := c.Get("log.format"); f {
+	case "text":
+	case "json":
+		json = true
+	default:
+		return fmt.Errorf("config: log.format: unknown format %q, expected text or json", f)
+	}
+
+	var w io.Writer
+
+	switch path := c.Get("log.output"); path {
+	case "stderr":
+		w = os.Stderr
+	case "stdout", "-":
+		w = os.Stdout
+	default:
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+		if err != nil {
+			return fmt.Errorf("config: log.output: %v", err)
+		}
+
+		w = f
+	}
+
+	middleware.DefaultLog = middleware.NewLog(w, level, json)
+	return nil
+}
+
+// Get returns the effective value of the setting name.
+func (c configFlags) Get(name string) string {
+	for _, s := range c.settings {
+		if s.Name == name {
+			return s.Value
+		}
+	}
+
+	return ""
+}
+
+// isProvider reports whether name is a provider, and cmd is either nil
+// or the provider command or one of it's sub commands.
+func isProvider(cmd *cobra.Command, name string) bool {
+	found := false
+
+	for _, p := range geo.Providers() {
+		found = found || p == name
+	}
+
+	if !found || cmd == nil {
+		return found
+	}
+
+	for ; cmd != nil; cmd = cmd.Parent() {
+		if cmd.Use == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withDefaultProvider prepends the default provider to args, unless args
+// already starts with a command.
+func withDefaultProvider(root *cobra.Command, provider string, args []string) []string {
+	if len(provider) == 0 || len(args) == 0 {
+		return args
+	}
+
+	if args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		return args
+	}
+
+	if cmd, _, err := root.Find(args); err == nil && cmd != root {
+		return args
+	}
+
+	return append([]string{provider}, args...)
+}
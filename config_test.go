@@ -0,0 +1,198 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	cmd := &cobra.Command{Use: "http"}
+	cmd.Flags().String("port", "localhost:8080", "")
+	cmd.Flags().String("log-level", "info", "")
+	cmd.Flags().String("log-format", "text", "")
+
+	var c configFlags
+	c.file.Server.Port = "file:1"
+	c.file.Log.Level = "warn"
+	c.file.Log.Format = "json"
+
+	os.Setenv("GOGEO_PORT", "env:2")
+	os.Setenv("GOGEO_LOG_LEVEL", "error")
+	defer os.Unsetenv("GOGEO_PORT")
+	defer os.Unsetenv("GOGEO_LOG_LEVEL")
+
+	assert.Nil(t, cmd.Flags().Set("port", "flag:3"))
+	c.resolve(cmd)
+
+	for name, expected := range map[string]setting{
+		"server.port":   {Value: "flag:3", Source: "flag --port"},
+		"log.level":     {Value: "error", Source: "env GOGEO_LOG_LEVEL"},
+		"log.format":    {Value: "json", Source: "config"},
+		"log.output":    {Value: "stderr", Source: "default"},
+		"server.auth":   {Value: "", Source: "default"},
+		"google.key":    {Value: "", Source: "default"},
+		"cache.size":    {Value: "1000", Source: "default"},
+		"server.socket": {Value: "", Source: "default"},
+	} {
+		var s setting
+
+		for _, s = range c.settings {
+			if s.Name == name {
+				break
+			}
+		}
+
+		assert.Equal(t, name, s.Name)
+		assert.Equal(t, expected.Value, s.Value, name)
+		assert.Equal(t, expected.Source, s.Source, name)
+	}
+
+	// an empty env variable doesn't override the config file
+	os.Setenv("GOGEO_LOG_FORMAT", "")
+	defer os.Unsetenv("GOGEO_LOG_FORMAT")
+	c.resolve(cmd)
+	assert.Equal(t, "json", c.Get("log.format"))
+}
+
+func TestConfigProviderKey(t *testing.T) {
+	root := &cobra.Command{Use: "gogeo"}
+	http := &cobra.Command{Use: "http"}
+	http.Flags().String("google-key", "", "")
+	google := &cobra.Command{Use: "google"}
+	google.Flags().String("key", "", "")
+	root.AddCommand(http, google)
+
+	var c configFlags
+	c.file.Providers = map[string]providerConfig{"google": {Key: "file-key"}}
+
+	// the server takes --google-key, the provider command --key
+	assert.Nil(t, http.Flags().Set("google-key", "server-key"))
+	c.resolve(http)
+	assert.Equal(t, "server-key", c.Get("google.key"))
+
+	c.resolve(google)
+	assert.Equal(t, "file-key", c.Get("google.key"))
+
+	assert.Nil(t, google.Flags().Set("key", "cli-key"))
+	c.resolve(google)
+	assert.Equal(t, "cli-key", c.Get("google.key"))
+}
+
+func TestConfigNewWithKey(t *testing.T) {
+	var keys []string
+	f := geo.FetcherFunc(func(req *http.Request) (*http.Response, error) {
+		keys = append(keys, req.URL.Query().Get("key"))
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(`{"status":"ZERO_RESULTS","results":[]}`)),
+		}, nil
+	})
+
+	root := &cobra.Command{Use: "gogeo"}
+	cmd := &cobra.Command{Use: "http"}
+	cmd.Flags().String("google-key", "", "")
+	root.AddCommand(cmd)
+
+	c := configFlags{fetchers: map[string]geo.Fetcher{"google": f}}
+	c.file.Providers = map[string]providerConfig{"google": {Key: "file-key"}}
+	assert.Nil(t, root.ParseFlags(nil))
+	assert.Nil(t, cmd.ParseFlags([]string{"--google-key", "server-key"}))
+	c.resolve(cmd)
+
+	// the server's providers are created without a key, so they use the flag
+	p, err := c.NewWithKey("Google", "")
+	assert.Nil(t, err)
+	p.Address("valby")
+
+	// a key, from the key query parameter, wins over the flag
+	p, err = c.NewWithKey("google", "query-key")
+	assert.Nil(t, err)
+	p.Address("valby")
+
+	assert.Equal(t, []string{"server-key", "query-key"}, keys)
+}
+
+func TestConfigArg(t *testing.T) {
+	for _, test := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"google", "-a", "valby"}, ""},
+		{[]string{"--config", "a.yml", "google"}, "a.yml"},
+		{[]string{"google", "--config=b.yml"}, "b.yml"},
+		{[]string{"-v", "--config", "c.yml"}, "c.yml"},
+		// flags after -- are arguments
+		{[]string{"google", "--", "--config", "a.yml"}, ""},
+		{[]string{"--config"}, ""},
+		{nil, ""},
+	} {
+		assert.Equal(t, test.expected, configArg(test.args), test.args)
+	}
+}
+
+func TestWithDefaultProvider(t *testing.T) {
+	root := &cobra.Command{Use: "gogeo"}
+	google := &cobra.Command{Use: "google"}
+	google.AddCommand(&cobra.Command{Use: "img"})
+	root.AddCommand(google, &cobra.Command{Use: "http"})
+
+	for _, test := range []struct {
+		provider string
+		args     []string
+		expected []string
+	}{
+		{"google", []string{"-a", "valby"}, []string{"google", "-a", "valby"}},
+		{"google", []string{"img", "-a", "valby"}, []string{"google", "img", "-a", "valby"}},
+		{"google", []string{"http", "-p", ":80"}, []string{"http", "-p", ":80"}},
+		{"google", []string{"google", "-a", "valby"}, []string{"google", "-a", "valby"}},
+		{"google", []string{"--help"}, []string{"--help"}},
+		{"google", []string{"help"}, []string{"help"}},
+		{"google", []string{}, []string{}},
+		{"", []string{"-a", "valby"}, []string{"-a", "valby"}},
+	} {
+		assert.Equal(t, test.expected, withDefaultProvider(root, test.provider, test.args), test.args)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gogeo")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	home := os.Getenv("XDG_CONFIG_HOME")
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	defer os.Setenv("XDG_CONFIG_HOME", home)
+
+	// a missing default config file is empty
+	cfg, err := loadConfig("")
+	assert.Nil(t, err)
+	assert.Equal(t, "", cfg.Default)
+
+	// a missing explicit one isn't
+	_, err = loadConfig(filepath.Join(dir, "missing.yml"))
+	assert.NotNil(t, err)
+
+	path := filepath.Join(dir, "gogeo", "config.yml")
+	os.MkdirAll(filepath.Dir(path), 0755)
+	ioutil.WriteFile(path, []byte("default: google\nproviders:\n  google:\n    key: abc\nserver:\n  cors:\n    origins: [\"https://example.com\"]\n"), 0644)
+
+	cfg, err = loadConfig("")
+	assert.Nil(t, err)
+	assert.Equal(t, "google", cfg.Default)
+	assert.Equal(t, "abc", cfg.Providers["google"].Key)
+	assert.Equal(t, []string{"https://example.com"}, cfg.Server.CORS.Origins)
+
+	ioutil.WriteFile(path, []byte("default: [google"), 0644)
+	_, err = loadConfig(path)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), path)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsPolicy is the cross-origin policy of the rest service. Origins are
+// exact origins like https://maps.example.com, wildcard subdomains like
+// https://*.example.com, or * for any origin.
+type corsPolicy struct {
+	Origins     []string
+	Methods     []string
+	Headers     []string
+	Credentials bool
+	MaxAge      time.Duration
+}
+
+// corsHeaders are the request headers allowed by default.
+const corsHeaders = "Accept, Authorization, Content-Type, If-None-Match, X-API-Key, X-Request-ID, traceparent"
+
+// corsExposed are the response headers, which the browser lets scripts
+// read.
+const corsExposed = "ETag, Retry-After, X-Request-ID, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset"
+
+// Handler is the alice middleware, which adds the CORS headers to requests
+// from allowed origins, and answers preflight requests. Preflights of
+// origins, methods or headers outside the policy gets a 403.
+func (c *corsPolicy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		preflight := req.Method == "OPTIONS" && len(req.Header.Get("Access-Control-Request-Method")) > 0
+		h := w.Header()
+
+		if len(origin) == 0 {
+			if req.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		h.Add("Vary", "Origin")
+
+		if !c.allowOrigin(origin) {
+			if preflight {
+				writeError(w, http.StatusForbidden, fmt.Sprintf("cors: origin %s is not allowed", origin))
+				return
+			}
+			// the browser blocks the response without the cors headers.
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		if contains(c.Origins, "*") {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if c.Credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			h.Set("Access-Control-Expose-Headers", corsExposed)
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+
+		if method := req.Header.Get("Access-Control-Request-Method"); !contains(c.Methods, method) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("cors: method %s is not allowed", method))
+			return
+		}
+
+		headers := req.Header.Get("Access-Control-Request-Headers")
+
+		for _, header := range strings.Split(headers, ",") {
+			if header = strings.TrimSpace(header); len(header) > 0 && !contains(c.Headers, header) && !contains(c.Headers, "*") {
+				writeError(w, http.StatusForbidden, fmt.Sprintf("cors: header %s is not allowed", header))
+				return
+			}
+		}
+
+		h.Set("Access-Control-Allow-Methods", strings.Join(c.Methods, ", "))
+
+		if contains(c.Headers, "*") {
+			h.Set("Access-Control-Allow-Headers", headers)
+		} else {
+			h.Set("Access-Control-Allow-Headers", strings.Join(c.Headers, ", "))
+		}
+
+		if c.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.FormatInt(int64(c.MaxAge/time.Second), 10))
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// allowOrigin reports whether the origin matches one of the origins of
+// the policy.
+func (c *corsPolicy) allowOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+
+	for _, o := range c.Origins {
+		o = strings.ToLower(o)
+
+		if o == "*" || o == origin {
+			return true
+		}
+
+		// https://*.example.com matches any subdomain, but not example.com
+		if i := strings.Index(o, "*."); i > 0 {
+			prefix, suffix := o[:i], o[i+1:]
+			sub := strings.TrimSuffix(strings.TrimPrefix(origin, prefix), suffix)
+
+			if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+				len(origin) > len(prefix)+len(suffix) && !strings.ContainsAny(sub, "/:") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// validate checks the origin patterns, and normalizes the methods to
+// upper case. Credentials aren't allowed with any origin, as it would let
+// every site make authenticated requests.
+func (c *corsPolicy) validate() error {
+	for _, o := range c.Origins {
+		if o == "*" && c.Credentials {
+			return fmt.Errorf("origins: * is not allowed with credentials, list the origins instead")
+		}
+
+		if o != "*" && (strings.Count(o, "*") > 1 || strings.Contains(o, "*") && !strings.Contains(o, "://*.")) {
+			return fmt.Errorf("origins: %q, expected *, an origin or a wildcard subdomain like https://*.example.com", o)
+		}
+	}
+
+	for i, m := range c.Methods {
+		c.Methods[i] = strings.ToUpper(m)
+	}
+
+	if c.MaxAge < 0 {
+		return fmt.Errorf("max_age: must be a duration of 0s or more")
+	}
+
+	return nil
+}
+
+// splitList splits a comma separated list, and drops empty values.
+func splitList(s string) []string {
+	var l []string
+
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			l = append(l, v)
+		}
+	}
+
+	return l
+}
+
+func contains(list []string, v string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, v) {
+			return true
+		}
+	}
+
+	return false
+}
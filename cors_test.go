@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORSOrigins(t *testing.T) {
+	c := &corsPolicy{Origins: []string{"https://maps.example.com", "https://*.example.org"}}
+
+	assert.True(t, c.allowOrigin("https://maps.example.com"))
+	assert.True(t, c.allowOrigin("https://MAPS.example.com"))
+	assert.True(t, c.allowOrigin("https://a.example.org"))
+	assert.True(t, c.allowOrigin("https://a.b.example.org"))
+	assert.False(t, c.allowOrigin("https://example.org"))
+	assert.False(t, c.allowOrigin("http://a.example.org"))
+	assert.False(t, c.allowOrigin("https://a.example.org:8443"))
+	assert.False(t, c.allowOrigin("https://evil.com/.example.org"))
+	assert.False(t, c.allowOrigin("https://maps.example.com.evil.com"))
+
+	assert.Nil(t, (&corsPolicy{Origins: []string{"*", "https://*.example.com:8443"}}).validate())
+	assert.NotNil(t, (&corsPolicy{Origins: []string{"https://maps.*.com"}}).validate())
+	assert.NotNil(t, (&corsPolicy{Origins: []string{"https://*.*.com"}}).validate())
+	assert.Nil(t, (&corsPolicy{Origins: []string{"https://*.example.com"}, Credentials: true}).validate())
+	assert.NotNil(t, (&corsPolicy{Origins: []string{"https://maps.example.com", "*"}, Credentials: true}).validate())
+}
+
+func TestCORSHandler(t *testing.T) {
+	c := &corsPolicy{
+		Origins: []string{"https://*.example.com"},
+		Methods: []string{"GET", "OPTIONS"},
+		Headers: splitList(corsHeaders),
+		MaxAge:  10 * time.Minute,
+	}
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+
+	send := func(method, origin string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/google/json", nil)
+		if len(origin) > 0 {
+			req.Header.Set("Origin", origin)
+		}
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("GET", "")
+	assert.Equal(t, "[]", w.Body.String())
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = send("GET", "https://maps.example.com")
+	assert.Equal(t, "https://maps.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+	assert.Contains(t, w.Header().Get("Access-Control-Expose-Headers"), "ETag")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+
+	// the response is served, but the browser blocks it
+	w = send("GET", "https://evil.com")
+	assert.Equal(t, "[]", w.Body.String())
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = send("OPTIONS", "https://maps.example.com", "Access-Control-Request-Method", "GET", "Access-Control-Request-Headers", "authorization, x-request-id")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "GET, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, corsHeaders, w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	assert.Empty(t, w.Body.String())
+
+	w = send("OPTIONS", "https://evil.com", "Access-Control-Request-Method", "GET")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = send("OPTIONS", "https://maps.example.com", "Access-Control-Request-Method", "DELETE")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = send("OPTIONS", "https://maps.example.com", "Access-Control-Request-Method", "GET", "Access-Control-Request-Headers", "x-custom")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	c.Credentials = true
+	w = send("GET", "https://maps.example.com")
+	assert.Equal(t, "https://maps.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+
+	c.Origins, c.Credentials = []string{"*"}, false
+	w = send("GET", "https://other.com")
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+// csvColumns is the stable column order of the csv and tsv encodings.
+var csvColumns = []string{
+	"query", "address", "street", "zip", "city", "state", "country",
+	"lat", "lng", "provider", "error",
+}
+
+// keepErrors reports whether the encoder writes failed lookups to it's
+// error column. The other encodings skip them.
+func keepErrors(encoder string) bool {
+	_, ok := encoders[encoder].(csvEncoding)
+	return ok
+}
+
+type csvEncoding struct {
+	Comma    rune
+	NoHeader bool
+}
+
+// With returns a copy of the encoding using delimiter, if not empty, and
+// with or without the header row.
+func (e csvEncoding) With(delimiter string, header bool) (csvEncoding, error) {
+	e.NoHeader = !header
+
+	if len(delimiter) == 0 {
+		return e, nil
+	}
+
+	if delimiter == `\t` {
+		delimiter = "\t"
+	}
+
+	r, size := utf8.DecodeRuneInString(delimiter)
+
+	if size != len(delimiter) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return e, fmt.Errorf("invalid delimiter: %q", delimiter)
+	}
+
+	e.Comma = r
+	return e, nil
+}
+
+// marshalDelimited marshals v just like Marshal, but the csv and tsv
+// encodings are customized with delimiter and header.
+func marshalDelimited(encoder string, v interface{}, pretty bool, delimiter string, header bool) ([]byte, error) {
+	e, ok := encoders[encoder].(csvEncoding)
+
+	if !ok {
+		return Marshal(encoder, v, pretty)
+	}
+
+	e, err := e.With(delimiter, header)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return e.Marshal(v, pretty)
+}
+
+func (e csvEncoding) Marshal(v interface{}, pretty bool) ([]byte, error) {
+	results, err := toResults(v)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = e.Comma
+
+	if !e.NoHeader {
+		w.Write(csvColumns)
+	}
+
+	for _, r := range results {
+		w.Write(csvRecord(r))
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func csvRecord(r geo.Result) []string {
+	lat, lng := "", ""
+
+	if len(r.Error) == 0 {
+		lat = strconv.FormatFloat(r.Latitude, 'f', -1, 64)
+		lng = strconv.FormatFloat(r.Longitude, 'f', -1, 64)
+	}
+
+	return []string{
+		r.Query, r.Address, r.Street, r.Zip, r.City, r.State, r.Country,
+		lat, lng, r.Provider, r.Error,
+	}
+}
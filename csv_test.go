@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+var csvTestResults = []geo.Result{
+	{
+		Query:    "vigerslev alle 77, valby",
+		Address:  `Vigerslev Allé 77, 2500 "Valby"`,
+		Street:   "Vigerslev Allé 77",
+		Zip:      "2500",
+		City:     "København",
+		Country:  "Denmark",
+		Location: geo.Location{Latitude: 55.6645, Longitude: 12.5048},
+		Provider: "google",
+	},
+	{Query: "nowhere", Provider: "google", Error: "result: ZERO_RESULTS"},
+}
+
+func TestCSVEncoding(t *testing.T) {
+	b, err := Marshal("csv", &csvTestResults, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "query,address,street,zip,city,state,country,lat,lng,provider,error\n"+
+		`"vigerslev alle 77, valby","Vigerslev Allé 77, 2500 ""Valby""",Vigerslev Allé 77,2500,København,,Denmark,55.6645,12.5048,google,`+"\n"+
+		"nowhere,,,,,,,,,google,result: ZERO_RESULTS\n", string(b))
+
+	b, err = Marshal("tsv", &csvTestResults, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "nowhere\t\t\t\t\t\t\t\t\tgoogle\tresult: ZERO_RESULTS\n", string(b[len(b)-44:]))
+}
+
+func TestCSVDelimiter(t *testing.T) {
+	b, err := marshalDelimited("csv", &csvTestResults, false, ";", false)
+	assert.Nil(t, err)
+	assert.Equal(t, `vigerslev alle 77, valby;"Vigerslev Allé 77, 2500 ""Valby""";Vigerslev Allé 77;2500;København;;Denmark;55.6645;12.5048;google;`+"\n"+
+		"nowhere;;;;;;;;;google;result: ZERO_RESULTS\n", string(b))
+
+	b, err = marshalDelimited("csv", &csvTestResults, false, `\t`, true)
+	assert.Nil(t, err)
+	assert.Equal(t, "query\taddress\t", string(b[:14]))
+
+	for _, d := range []string{`"`, "\n", ";;", "é;"} {
+		_, err = marshalDelimited("csv", &csvTestResults, false, d, true)
+		assert.NotNil(t, err, d)
+	}
+
+	// the delimiter is ignored by the other encodings
+	b, err = marshalDelimited("json", &csvTestResults, false, ";", false)
+	assert.Nil(t, err)
+	assert.Equal(t, byte('['), b[0])
+}
+
+func TestKeepErrors(t *testing.T) {
+	assert.True(t, keepErrors("csv"))
+	assert.True(t, keepErrors("tsv"))
+	assert.False(t, keepErrors("json"))
+	assert.False(t, keepErrors("ndjson"))
+}
@@ -1,26 +1,104 @@
 package main
 
 import (
+	_ "embed"
 	"encoding/json"
 	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v2"
+
+	"github.com/harboe/gogeo/geo"
 )
 
 type (
 	Encoding interface {
 		Marshal(v interface{}, pretty bool) ([]byte, error)
 	}
+	// StreamEncoding can write each result as soon as it's available,
+	// instead of marshaling all of them at once.
+	StreamEncoding interface {
+		Encoding
+		NewEncoder(w io.Writer) StreamEncoder
+	}
+	StreamEncoder interface {
+		Encode(r geo.Result) error
+	}
 	jsonEncoding struct{}
 	xmlEncoding  struct{}
 	yamlEncoding struct{}
+
+	// xmlResults is the root element of the xml encoding
+	xmlResults struct {
+		XMLName   xml.Name    `xml:"results"`
+		Namespace string      `xml:"xmlns,attr"`
+		Results   []xmlResult `xml:"result"`
+	}
+	// xmlResult nests the location, which is flattened in geo.Result
+	xmlResult struct {
+		Query    string        `xml:"query,attr"`
+		Provider string        `xml:"provider,attr,omitempty"`
+		Address  string        `xml:"address"`
+		Street   string        `xml:"street,omitempty"`
+		Country  string        `xml:"country"`
+		City     string        `xml:"city,omitempty"`
+		Zip      string        `xml:"zip,omitempty"`
+		State    string        `xml:"state,omitempty"`
+		Location *geo.Location `xml:"location,omitempty"`
+		Bounds   *geo.Bounds   `xml:"bounds,omitempty"`
+		Error    string        `xml:"error,omitempty"`
+	}
 )
 
+// xmlNamespace is the target namespace of results.xsd
+const xmlNamespace = "https://github.com/harboe/gogeo/results"
+
+// resultsXSD is the schema of the xml encoding
+//
+//go:embed results.xsd
+var resultsXSD []byte
+
 var (
 	encoders = map[string]Encoding{
-		"json": jsonEncoding{},
-		"xml":  xmlEncoding{},
-		"yml":  yamlEncoding{},
+		"json":    jsonEncoding{},
+		"xml":     xmlEncoding{},
+		"yml":     yamlEncoding{},
+		"geojson": geojsonEncoding{},
+		"csv":     csvEncoding{Comma: ','},
+		"tsv":     csvEncoding{Comma: '\t'},
+		"kml":     kmlEncoding{},
+		"gpx":     gpxEncoding{},
+		"ndjson":  ndjsonEncoding{},
+	}
+	contentTypes = map[string]string{
+		"json":    "application/json; charset=utf-8",
+		"xml":     "application/xml; charset=utf-8",
+		"yml":     "application/x-yaml; charset=utf-8",
+		"geojson": "application/geo+json; charset=utf-8",
+		"csv":     "text/csv; charset=utf-8",
+		"tsv":     "text/tab-separated-values; charset=utf-8",
+		"kml":     "application/vnd.google-earth.kml+xml; charset=utf-8",
+		"gpx":     "application/gpx+xml; charset=utf-8",
+		"ndjson":  "application/x-ndjson; charset=utf-8",
+	}
+	// mediaTypes maps the Accept header to the encoders, in order of
+	// preference when the client accepts more than one equally.
+	mediaTypes = []struct{ Type, Encoder string }{
+		{"application/json", "json"},
+		{"application/xml", "xml"},
+		{"text/xml", "xml"},
+		{"application/x-yaml", "yml"},
+		{"application/yaml", "yml"},
+		{"text/yaml", "yml"},
+		{"application/geo+json", "geojson"},
+		{"text/csv", "csv"},
+		{"text/tab-separated-values", "tsv"},
+		{"application/vnd.google-earth.kml+xml", "kml"},
+		{"application/gpx+xml", "gpx"},
+		{"application/x-ndjson", "ndjson"},
 	}
 )
 
@@ -32,12 +110,37 @@ func (e jsonEncoding) Marshal(v interface{}, pretty bool) ([]byte, error) {
 	}
 }
 
+// Marshal encodes results as a <results> document, described by the
+// results.xsd schema. Anything else is marshaled as is.
 func (e xmlEncoding) Marshal(v interface{}, pretty bool) ([]byte, error) {
-	if pretty {
-		return xml.MarshalIndent(&v, "", "  ")
-	} else {
-		return xml.Marshal(&v)
+	if results, err := toResults(v); err == nil {
+		doc := &xmlResults{Namespace: xmlNamespace, Results: []xmlResult{}}
+
+		for _, r := range results {
+			doc.Results = append(doc.Results, xmlResult{
+				Query:    r.Query,
+				Provider: r.Provider,
+				Address:  r.Address,
+				Street:   r.Street,
+				Country:  r.Country,
+				City:     r.City,
+				Zip:      r.Zip,
+				State:    r.State,
+				Bounds:   r.Bounds,
+				Error:    r.Error,
+			})
+
+			// a failed lookup has no location, rather than 0,0.
+			if len(r.Error) == 0 {
+				loc := r.Location
+				doc.Results[len(doc.Results)-1].Location = &loc
+			}
+		}
+
+		v = doc
 	}
+
+	return marshalXMLDocument(v, pretty)
 }
 
 func (e yamlEncoding) Marshal(v interface{}, pretty bool) ([]byte, error) {
@@ -53,3 +156,87 @@ func Marshal(encoder string, v interface{}, pretty bool) ([]byte, error) {
 
 	return e.Marshal(v, pretty)
 }
+
+// ContentType returns the mime type of the encoder, and falls back to json
+// just like Marshal.
+func ContentType(encoder string) string {
+	if t, ok := contentTypes[encoder]; ok {
+		return t
+	}
+
+	return contentTypes["json"]
+}
+
+// toResults is used by encodings which only knows how to encode results.
+func toResults(v interface{}) ([]geo.Result, error) {
+	switch r := v.(type) {
+	case *[]geo.Result:
+		return *r, nil
+	case []geo.Result:
+		return r, nil
+	case geo.Result:
+		return []geo.Result{r}, nil
+	case *geo.Result:
+		return []geo.Result{*r}, nil
+	}
+
+	return nil, fmt.Errorf("unable to encode %T", v)
+}
+
+// Negotiate returns the encoder of the Accept header, the one with the
+// highest quality, and json if the header is empty. It reports false, if
+// none of the encoders are acceptable.
+func Negotiate(accept string) (string, bool) {
+	if len(strings.TrimSpace(accept)) == 0 {
+		return "json", true
+	}
+
+	best, bestQ := "", 0.0
+
+	for _, m := range mediaTypes {
+		if q := acceptQuality(accept, m.Type); q > bestQ {
+			best, bestQ = m.Encoder, q
+		}
+	}
+
+	return best, bestQ > 0
+}
+
+// acceptQuality returns the q value of the most specific media range of
+// the Accept header, which matches the media type, or 0.
+func acceptQuality(accept, mediaType string) float64 {
+	q, specificity := 0.0, 0
+
+	for _, r := range strings.Split(accept, ",") {
+		params := strings.Split(r, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		s := 0
+
+		switch {
+		case name == mediaType:
+			s = 3
+		case name == "*/*":
+			s = 1
+		case strings.HasSuffix(name, "/*") && strings.HasPrefix(mediaType, name[:len(name)-1]):
+			s = 2
+		}
+
+		if s <= specificity {
+			continue
+		}
+
+		specificity, q = s, 1
+
+		for _, p := range params[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(p), "=", 2); len(kv) == 2 && strings.EqualFold(kv[0], "q") {
+				if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					q = v
+				} else {
+					q = 0
+				}
+			}
+		}
+	}
+
+	return q
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+type (
+	// xsd is the subset of xml schema used by results.xsd
+	xsd struct {
+		TargetNamespace string       `xml:"targetNamespace,attr"`
+		Elements        []xsdElement `xml:"element"`
+		Types           []xsdType    `xml:"complexType"`
+	}
+	xsdType struct {
+		Name       string         `xml:"name,attr"`
+		Sequence   []xsdElement   `xml:"sequence>element"`
+		Attributes []xsdAttribute `xml:"attribute"`
+	}
+	xsdElement struct {
+		Name      string `xml:"name,attr"`
+		Type      string `xml:"type,attr"`
+		MinOccurs string `xml:"minOccurs,attr"`
+		MaxOccurs string `xml:"maxOccurs,attr"`
+	}
+	xsdAttribute struct {
+		Name string `xml:"name,attr"`
+		Type string `xml:"type,attr"`
+		Use  string `xml:"use,attr"`
+	}
+	// node is a generic xml element
+	node struct {
+		XMLName  xml.Name
+		Attrs    []xml.Attr `xml:",any,attr"`
+		Content  string     `xml:",chardata"`
+		Children []node     `xml:",any"`
+	}
+)
+
+var xmlTestResults = []geo.Result{
+	{
+		Query:    "alekistevej 203, vanlose",
+		Provider: "google",
+		Address:  "Ålekistevej 203, 2720 Vanløse, Denmark",
+		Street:   "Ålekistevej 203",
+		Country:  "Denmark",
+		City:     "København",
+		Zip:      "2720",
+		Location: geo.Location{Latitude: 55.694639, Longitude: 12.4796647},
+		Bounds: &geo.Bounds{
+			SouthWest: geo.Location{Latitude: 55.6932, Longitude: 12.4783},
+			NorthEast: geo.Location{Latitude: 55.6959, Longitude: 12.4810},
+		},
+	},
+	{Query: "nowhere", Provider: "google", Error: "result: ZERO_RESULTS"},
+}
+
+func TestNegotiate(t *testing.T) {
+	for accept, expected := range map[string]string{
+		"":                                      "json",
+		"*/*":                                   "json",
+		"application/xml":                       "xml",
+		"text/xml; charset=utf-8":               "xml",
+		"application/x-yaml":                    "yml",
+		"application/geo+json":                  "geojson",
+		"text/*":                                "xml",
+		"text/csv;q=0.5, application/xml;q=0.9": "xml",
+		"*/*;q=0.1, text/csv":                   "csv",
+		"application/json;q=0, application/*;q=.5": "xml",
+	} {
+		format, ok := Negotiate(accept)
+		assert.True(t, ok, accept)
+		assert.Equal(t, expected, format, accept)
+	}
+
+	for _, accept := range []string{"image/png", "application/json;q=0", "text/html, */*;q=0"} {
+		_, ok := Negotiate(accept)
+		assert.False(t, ok, accept)
+	}
+}
+
+func TestXMLEncodingSchema(t *testing.T) {
+	var schema xsd
+	assert.Nil(t, xml.Unmarshal(resultsXSD, &schema))
+	assert.Equal(t, xmlNamespace, schema.TargetNamespace)
+
+	for _, pretty := range []bool{false, true} {
+		b, err := Marshal("xml", &xmlTestResults, pretty)
+		assert.Nil(t, err)
+
+		var doc node
+		assert.Nil(t, xml.Unmarshal(b, &doc))
+		assert.Nil(t, schema.validate(doc), string(b))
+		assert.Equal(t, 2, len(doc.Children))
+	}
+
+	empty := []geo.Result{}
+	b, err := Marshal("xml", &empty, false)
+	assert.Nil(t, err)
+
+	var doc node
+	assert.Nil(t, xml.Unmarshal(b, &doc))
+	assert.Nil(t, schema.validate(doc))
+
+	// documents the schema doesn't allow are caught
+	results := func(children string) string {
+		return `<results xmlns="` + xmlNamespace + `">` + children + `</results>`
+	}
+
+	for _, test := range []struct {
+		doc, err string
+	}{
+		{`<results xmlns="other"></results>`, `namespace "other", expected "` + xmlNamespace + `"`},
+		{`<result xmlns="` + xmlNamespace + `"></result>`, "undeclared root element <result>"},
+		{results(`<result><address/><country/></result>`), "<result>: missing attribute query"},
+		{results(`<result query="q" id="1"><address/><country/></result>`), "<result>: undeclared attribute id"},
+		{results(`<result query="q"><address/></result>`), "<result>: missing element <country>"},
+		{results(`<result query="q"><country/><address/></result>`), "<result>: missing element <address>"},
+		{results(`<result query="q"><address/><address/><country/></result>`), "<result>: too many <address>"},
+		{results(`<result query="q"><address/><country/><location lat="north" lng="1"/></result>`), `<location lat="north">: not a double`},
+		{results(`<result query="q"><address/><country/><bounds><sw lat="1" lng="1"/></bounds></result>`), "<bounds>: missing element <ne>"},
+	} {
+		var doc node
+		assert.Nil(t, xml.Unmarshal([]byte(test.doc), &doc))
+		assert.EqualError(t, schema.validate(doc), test.err, test.doc)
+	}
+}
+
+func TestXMLEncoding(t *testing.T) {
+	b, err := Marshal("xml", &xmlTestResults, false)
+	assert.Nil(t, err)
+	assert.Equal(t, xml.Header+`<results xmlns="`+xmlNamespace+`">`+
+		`<result query="alekistevej 203, vanlose" provider="google">`+
+		`<address>Ålekistevej 203, 2720 Vanløse, Denmark</address><street>Ålekistevej 203</street>`+
+		`<country>Denmark</country><city>København</city><zip>2720</zip>`+
+		`<location lat="55.694639" lng="12.4796647"></location>`+
+		`<bounds><sw lat="55.6932" lng="12.4783"></sw><ne lat="55.6959" lng="12.481"></ne></bounds>`+
+		`</result>`+
+		// a failed lookup has no location
+		`<result query="nowhere" provider="google"><address></address><country></country><error>result: ZERO_RESULTS</error></result>`+
+		`</results>`, string(b))
+
+	empty := []geo.Result{}
+	b, err = Marshal("xml", &empty, false)
+	assert.Nil(t, err)
+	assert.Equal(t, xml.Header+`<results xmlns="`+xmlNamespace+`"></results>`, string(b))
+}
+
+func (s xsd) validate(root node) error {
+	if root.XMLName.Space != s.TargetNamespace {
+		return fmt.Errorf("namespace %q, expected %q", root.XMLName.Space, s.TargetNamespace)
+	}
+
+	for _, e := range s.Elements {
+		if e.Name == root.XMLName.Local {
+			return s.validateType(root, e.Type)
+		}
+	}
+
+	return fmt.Errorf("undeclared root element <%s>", root.XMLName.Local)
+}
+
+func (s xsd) validateType(n node, typ string) error {
+	if typ == "xs:string" {
+		return nil
+	}
+
+	var t *xsdType
+	for i := range s.Types {
+		if s.Types[i].Name == typ {
+			t = &s.Types[i]
+		}
+	}
+
+	if t == nil {
+		return fmt.Errorf("<%s>: unknown type %s", n.XMLName.Local, typ)
+	}
+
+	attrs := map[string]string{}
+	for _, a := range n.Attrs {
+		if a.Name.Space == "" && a.Name.Local != "xmlns" {
+			attrs[a.Name.Local] = a.Value
+		}
+	}
+
+	for _, a := range t.Attributes {
+		v, ok := attrs[a.Name]
+		delete(attrs, a.Name)
+
+		if !ok && a.Use == "required" {
+			return fmt.Errorf("<%s>: missing attribute %s", n.XMLName.Local, a.Name)
+		}
+
+		if _, err := strconv.ParseFloat(v, 64); ok && a.Type == "xs:double" && err != nil {
+			return fmt.Errorf("<%s %s=%q>: not a double", n.XMLName.Local, a.Name, v)
+		}
+	}
+
+	for a := range attrs {
+		return fmt.Errorf("<%s>: undeclared attribute %s", n.XMLName.Local, a)
+	}
+
+	// children must follow the order of the sequence
+	i := 0
+	for _, e := range t.Sequence {
+		count := 0
+
+		for i < len(n.Children) && n.Children[i].XMLName.Local == e.Name {
+			if err := s.validateType(n.Children[i], e.Type); err != nil {
+				return err
+			}
+			if n.Children[i].XMLName.Space != s.TargetNamespace {
+				return fmt.Errorf("<%s>: wrong namespace", e.Name)
+			}
+			i++
+			count++
+		}
+
+		if count == 0 && e.MinOccurs != "0" {
+			return fmt.Errorf("<%s>: missing element <%s>", n.XMLName.Local, e.Name)
+		}
+
+		if count > 1 && e.MaxOccurs != "unbounded" {
+			return fmt.Errorf("<%s>: too many <%s>", n.XMLName.Local, e.Name)
+		}
+	}
+
+	if i < len(n.Children) {
+		return fmt.Errorf("<%s>: unexpected element <%s>", n.XMLName.Local, n.Children[i].XMLName.Local)
+	}
+
+	return nil
+}
@@ -3,7 +3,8 @@ package main
 import (
 	"fmt"
 	"net/http"
-	// "strings"
+	"path/filepath"
+	"strings"
 
 	"github.com/harboe/gogeo/geo"
 	"github.com/harboe/gogeo/geo/middleware"
@@ -14,35 +15,88 @@ type (
 	locationList []geo.Location
 
 	imageFlags struct {
-		Size  string
-		Zoom  uint64
-		Scale uint64
+		Size   string
+		Zoom   uint64
+		Scale  uint64
+		Path   locationList
+		Format string
 	}
 	formatFlags struct {
-		Yaml   bool
-		Json   bool
-		Xml    bool
-		Pretty bool
+		Yaml    bool
+		Json    bool
+		Xml     bool
+		GeoJson bool
+		Csv     bool
+		Tsv     bool
+		Kml     bool
+		Gpx     bool
+		NDJson  bool
+		Pretty  bool
+		// Delimiter and NoHeader only applies to csv and tsv
+		Delimiter string
+		NoHeader  bool
+		// Template overrides any other format
+		Template    string
+		TemplateAll bool
+		// Output filename, its extension selects the format if no format
+		// flag is given. Empty or "-" is stdout.
+		Output string
+		// Default format, if neither a format flag nor the output
+		// filename selects one.
+		Default string
 	}
 	configFlags struct {
 		Verbose bool
 		APIKey  string
+		Tiles   string
+		// File is the config file given with --config
+		File string
+
+		file     fileConfig
+		settings []setting
+		fetchers map[string]geo.Fetcher
+		breakers map[string]*middleware.Breaker
 	}
 )
 
+// extensions maps output file extensions to encoders
+var extensions = map[string]string{
+	".json":    "json",
+	".xml":     "xml",
+	".yml":     "yml",
+	".yaml":    "yml",
+	".geojson": "geojson",
+	".csv":     "csv",
+	".tsv":     "tsv",
+	".kml":     "kml",
+	".gpx":     "gpx",
+	".ndjson":  "ndjson",
+	".jsonl":   "ndjson",
+}
+
 func (c configFlags) New(name string) (geo.Provider, error) {
 	return c.NewWithKey(name, c.APIKey)
 }
 
 func (c configFlags) NewWithKey(name, key string) (geo.Provider, error) {
-	var f geo.Fetcher
+	name = strings.ToLower(name)
+	f := c.fetchers[name]
 
-	if c.Verbose {
-		f = middleware.Logger(http.DefaultClient)
+	if f == nil && c.Verbose {
+		f = middleware.Logger(http.DefaultClient, name)
 	}
 
-	return geo.New(name, geo.Config{APIKey: key, Fetcher: f})
+	if len(key) == 0 {
+		key = c.Get(name + ".key")
+	}
 
+	return geo.New(name, geo.Config{
+		APIKey:  key,
+		Fetcher: f,
+		Tiles:   c.Tiles,
+		GeoURL:  c.Get(name + ".geo_url"),
+		ImgURL:  c.Get(name + ".img_url"),
+	})
 }
 
 // String is the method to format the flag's value, part of the flag.Value interface.
@@ -83,8 +137,15 @@ func (i imageFlags) Map() (opt geo.MapOptions, err error) {
 	if opt.Size, err = geo.NewSize(i.Size); err != nil {
 		return
 	}
+	if opt.Format, err = geo.NewFormat(i.Format); err != nil {
+		return
+	}
 	opt.Zoom = i.Zoom
 	opt.Scale = i.Scale
+
+	for _, l := range i.Path {
+		opt.Path = append(opt.Path, l.String())
+	}
 	return
 }
 
@@ -96,16 +157,55 @@ func (f formatFlags) String() string {
 		return "yml"
 	case f.Xml:
 		return "xml"
+	case f.GeoJson:
+		return "geojson"
+	case f.Csv:
+		return "csv"
+	case f.Tsv:
+		return "tsv"
+	case f.Kml:
+		return "kml"
+	case f.Gpx:
+		return "gpx"
+	case f.NDJson:
+		return "ndjson"
+	}
+
+	if e, ok := extensions[strings.ToLower(filepath.Ext(f.Output))]; ok {
+		return e
+	}
+
+	if len(f.Default) > 0 {
+		return f.Default
 	}
 
 	return "json"
 }
 
+// Stdout reports whether the output is written to stdout.
+func (f formatFlags) Stdout() bool {
+	return len(f.Output) == 0 || f.Output == "-"
+}
+
 func (f formatFlags) Marshal(v interface{}) ([]byte, error) {
-	return Marshal(f.String(), v, f.Pretty)
+	if len(f.Template) > 0 {
+		t, err := parseTemplate(f.Template)
+
+		if err != nil {
+			return []byte{}, err
+		}
+
+		return templateEncoding{Template: t, All: f.TemplateAll}.Marshal(v, f.Pretty)
+	}
+
+	return marshalDelimited(f.String(), v, f.Pretty, f.Delimiter, !f.NoHeader)
 }
 
-func (f formatFlags) Filename(name string) string {
+// Filename returns the output filename, "-" means stdout.
+func (f formatFlags) Filename() string {
+	if f.Stdout() {
+		return "-"
+	}
 
-	return ""
+	return f.Output
 }
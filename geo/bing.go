@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"strings"
 )
 
 type (
@@ -21,6 +22,7 @@ type (
 	bingResource struct {
 		bingPoint   `json:"point"`
 		bingAddress `json:"address"`
+		BBox        []float64 `json:"bbox"`
 	}
 	bingResourceSet struct {
 		Total     int            `json:"estimatedTotal"`
@@ -39,6 +41,7 @@ type (
 func (api *bingAPI) Location(loc Location) (Result, error) {
 	qry := url.Values{}
 	qry.Add("o", "json")
+	api.addKey(qry)
 
 	url := fmt.Sprintf("%s/%s?%s", api.Geo, loc, qry.Encode())
 	return api.bingGeoService(url, loc.String())
@@ -49,31 +52,98 @@ func (api *bingAPI) Address(address string) (Result, error) {
 	qry.Add("q", address)
 	qry.Add("o", "json")
 	qry.Add("maxResults", "1")
+	api.addKey(qry)
 
 	url := fmt.Sprintf("%s?%s", api.Geo, qry.Encode())
 	return api.bingGeoService(url, address)
 }
 
-func (api *bingAPI) Image(address []string, opts MapOptions) ([]byte, error) {
-	// qry := url.Values{}
-
-	// markers := ""
-	// for _, a := range address {
-	// 	markers += fmt.Sprintf("%s|", a)
-	// }
-	// qry.Add("markers", markers)
-
-	// if opts.Zoom > 0 {
-	// 	qry.Add("zoom", fmt.Sprintf("%v", opts.Zoom))
-	// }
-	// if opts.Scale > 0 {
-	// 	qry.Add("scale", fmt.Sprintf("%v", opts.Scale))
-	// }
-	// qry.Add("size", opts.Size.String())
-
-	// url := fmt.Sprintf("%s?%s", imgurl, qry.Encode())
-	// return g.getResponseBody(url)
-	return []byte{}, errors.New("not implemeted")
+func (api *bingAPI) Image(markers []string, opts MapOptions) ([]byte, error) {
+	markers, opts, err := Fit(api, markers, opts)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	format, err := NewFormat(opts.Format)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	// bing pushpins and curves are coordinates, which they already are if
+	// the map was fitted.
+	if markers, err = resolve(api, markers); err != nil {
+		return []byte{}, err
+	}
+
+	if opts.Path, err = resolve(api, opts.Path); err != nil {
+		return []byte{}, err
+	}
+
+	// the zoom level is only taken together with a center, without either
+	// bing fits the map around the pushpins.
+	if opts.Center == nil && opts.Zoom > 0 {
+		// they're coordinates, so there is nothing to look up.
+		locs, _ := Locations(api, append(append([]string{}, markers...), opts.Path...))
+
+		if len(locs) > 0 {
+			c := NewBounds(locs...).Center()
+			opts.Center = &c
+		}
+	}
+
+	qry := url.Values{}
+	qry.Add("mapSize", fmt.Sprintf("%v,%v", opts.Size.Width, opts.Size.Height))
+
+	for _, m := range markers {
+		qry.Add("pp", m)
+	}
+
+	if len(opts.Path) > 0 {
+		qry.Add("dc", "l,FF2530D9,3;"+strings.Join(opts.Path, "_"))
+	}
+	if opts.Scale > 1 {
+		qry.Add("dpi", "Large")
+	}
+	api.addKey(qry)
+
+	// webp isn't supported by bing, and will be transcoded from png.
+	switch format {
+	case "jpg":
+		qry.Add("format", "jpeg")
+	case "webp":
+		qry.Add("format", "png")
+	default:
+		qry.Add("format", format)
+	}
+
+	u := api.Img
+
+	if opts.Center != nil {
+		zoom := opts.Zoom
+
+		if zoom < 1 {
+			zoom = 1
+		}
+
+		u = fmt.Sprintf("%s/%s/%v", u, opts.Center, zoom)
+	}
+
+	b, err := fetch(api.Fetcher, fmt.Sprintf("%s?%s", u, qry.Encode()))
+
+	if err != nil {
+		return b, err
+	}
+
+	return Transcode(b, format)
+}
+
+// addKey adds the api key to the query, if one is configured.
+func (api *bingAPI) addKey(qry url.Values) {
+	if len(api.APIKey) > 0 {
+		qry.Add("key", api.APIKey)
+	}
 }
 
 func (api *bingAPI) bingGeoService(url, qry string) (res Result, err error) {
@@ -90,8 +160,18 @@ func (api *bingAPI) bingGeoService(url, qry string) (res Result, err error) {
 
 	resx := v.Set[0].Resources[0]
 
+	// bing bounding boxes are [south, west, north, east]
+	var bounds *Bounds
+	if len(resx.BBox) == 4 {
+		bounds = &Bounds{
+			SouthWest: Location{resx.BBox[0], resx.BBox[1]},
+			NorthEast: Location{resx.BBox[2], resx.BBox[3]},
+		}
+	}
+
 	return Result{
 		Query:    qry,
+		Provider: "bing",
 		Address:  resx.Address,
 		Street:   resx.Street,
 		Country:  resx.Country,
@@ -99,5 +179,6 @@ func (api *bingAPI) bingGeoService(url, qry string) (res Result, err error) {
 		City:     resx.City,
 		State:    resx.State,
 		Location: Location{resx.Coords[0], resx.Coords[1]},
+		Bounds:   bounds,
 	}, nil
 }
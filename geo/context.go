@@ -0,0 +1,34 @@
+package geo
+
+import (
+	"context"
+	"net/http"
+)
+
+// WithContext returns a copy of the provider, which sends it's requests
+// with ctx, so they're canceled with ctx and the fetcher middlewares can
+// read the values of ctx. Providers without requests are returned as is.
+func WithContext(ctx context.Context, p Provider) Provider {
+	switch api := p.(type) {
+	case *googleAPI:
+		c := *api
+		c.Fetcher = contextFetcher(ctx, c.Fetcher)
+		return &c
+	case *bingAPI:
+		c := *api
+		c.Fetcher = contextFetcher(ctx, c.Fetcher)
+		return &c
+	case *mapquestAPI:
+		c := *api
+		c.Fetcher = contextFetcher(ctx, c.Fetcher)
+		return &c
+	}
+
+	return p
+}
+
+func contextFetcher(ctx context.Context, f Fetcher) Fetcher {
+	return FetcherFunc(func(req *http.Request) (*http.Response, error) {
+		return f.Do(req.WithContext(ctx))
+	})
+}
@@ -11,9 +11,9 @@ const (
 	googleGeoURL   = "https://maps.googleapis.com/maps/api/geocode/json"
 	googleImgURL   = "https://maps.googleapis.com/maps/api/staticmap"
 	bingGeoURL     = "https://dev.virtualearth.net/REST/v1/Locations"
-	bingImgURL     = ""
+	bingImgURL     = "https://dev.virtualearth.net/REST/v1/Imagery/Map/Road"
 	mapquestGeoURL = "https://open.mapquestapi.com/geocoding/v1/"
-	mapquestImgURL = ""
+	mapquestImgURL = "https://www.mapquestapi.com/staticmap/v5/map"
 )
 
 // Config represents optional provider configurations
@@ -24,11 +24,50 @@ type Config struct {
 	// APIKey to use in the geo service. If nothing is specificed it will
 	// try to find an Env variables name GOGEO_{name}
 	APIKey string
+	// Tiles is the path to a {z}/{x}/{y}.png directory or a .mbtiles file,
+	// used by the local provider. If nothing is specificed it will try to
+	// find an Env variable named GOGEO_TILES
+	Tiles string
+	// GeoURL and ImgURL overrides the geocoding and static map endpoints,
+	// eg. for a proxy or a self hosted service.
+	GeoURL string
+	ImgURL string
 }
 
 // Providers return a list of available providers
 func Providers() []string {
-	return []string{"bing", "google", "mapquest"}
+	return []string{"bing", "google", "local", "mapquest"}
+}
+
+// capabilities of each provider: geocode, reverse and image
+var capabilities = map[string][]string{
+	"bing":     {"geocode", "reverse", "image"},
+	"google":   {"geocode", "reverse", "image"},
+	"local":    {"reverse", "image"},
+	"mapquest": {"geocode", "reverse", "image"},
+}
+
+// Capabilities returns what the provider supports: geocode, reverse
+// and image.
+func Capabilities(name string) []string {
+	return capabilities[strings.ToLower(name)]
+}
+
+// RequiresKey reports whether the provider needs an api key.
+func RequiresKey(name string) bool {
+	return strings.ToLower(name) != "local"
+}
+
+// Ready returns an error if the provider can't serve requests, eg. the
+// local provider without a tile source. Keys are checked by the caller,
+// with RequiresKey.
+func Ready(p Provider) error {
+	if api, ok := p.(*localAPI); ok {
+		_, err := api.tiles()
+		return err
+	}
+
+	return nil
 }
 
 // New returns new instance of the provider specificed by name
@@ -49,18 +88,32 @@ func New(name string, opts ...Config) (Provider, error) {
 		cfg.APIKey = APIKey(name)
 	}
 
+	if len(cfg.Tiles) == 0 {
+		cfg.Tiles = os.Getenv("GOGEO_TILES")
+	}
+
 	switch strings.ToLower(name) {
 	case "google":
-		return &googleAPI{Config: cfg, Geo: googleGeoURL, Img: googleImgURL}, nil
+		return &googleAPI{Config: cfg, Geo: cfg.url(cfg.GeoURL, googleGeoURL), Img: cfg.url(cfg.ImgURL, googleImgURL)}, nil
 	case "bing":
-		return &bingAPI{Config: cfg, Geo: bingGeoURL, Img: bingImgURL}, nil
+		return &bingAPI{Config: cfg, Geo: cfg.url(cfg.GeoURL, bingGeoURL), Img: cfg.url(cfg.ImgURL, bingImgURL)}, nil
+	case "local":
+		return &localAPI{Config: cfg, Tiles: cfg.Tiles}, nil
 	case "mapquest":
-		return &mapquestAPI{Config: cfg, Geo: mapquestGeoURL, Img: mapquestImgURL}, nil
+		return &mapquestAPI{Config: cfg, Geo: cfg.url(cfg.GeoURL, mapquestGeoURL), Img: cfg.url(cfg.ImgURL, mapquestImgURL)}, nil
 	}
 
 	return nil, fmt.Errorf("not found: %s", name)
 }
 
+func (cfg Config) url(override, def string) string {
+	if len(override) > 0 {
+		return override
+	}
+
+	return def
+}
+
 func APIKey(key string) string {
 	return os.Getenv(fmt.Sprintf("GOGEO_%s", strings.ToUpper(key)))
 }
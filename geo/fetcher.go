@@ -21,13 +21,13 @@ func fetch(c Fetcher, url string) ([]byte, error) {
 	req, err := http.NewRequest("GET", url, nil)
 
 	if err != nil {
-		return []byte{}, err
+		return []byte{}, RedactError(err)
 	}
 
 	res, err := c.Do(req)
 
 	if err != nil {
-		return []byte{}, err
+		return []byte{}, RedactError(err)
 	}
 
 	defer res.Body.Close()
@@ -0,0 +1,84 @@
+package geo
+
+// DefaultPadding is the number of pixels kept free around the markers when
+// the zoom level is fitted.
+var DefaultPadding uint64 = 20
+
+// Locations converts markers to locations, markers which are not formatted
+// as {lat},{lng} are looked up using the provider.
+func Locations(p Provider, markers []string) ([]Location, error) {
+	locs := make([]Location, 0, len(markers))
+
+	for _, m := range markers {
+		if l, err := NewLocation(m); err == nil {
+			locs = append(locs, l)
+			continue
+		}
+
+		r, err := p.Address(m)
+
+		if err != nil {
+			return nil, err
+		}
+
+		locs = append(locs, r.Location)
+	}
+
+	return locs, nil
+}
+
+// Fit frames the map around all markers and the path, when no zoom is
+// specificed. The zoom and center is calculated from the bounding box, so
+// every provider frames a map the same way. The markers and path are
+// returned as {lat},{lng}, once they're looked up, so the static map
+// doesn't geocode them again.
+func Fit(p Provider, markers []string, opts MapOptions) ([]string, MapOptions, error) {
+	if opts.Zoom > 0 || opts.Center != nil {
+		return markers, opts, nil
+	}
+
+	if opts.Size.Width == 0 || opts.Size.Height == 0 {
+		opts.Size = DefaultSize
+	}
+
+	locs, err := Locations(p, append(append([]string{}, markers...), opts.Path...))
+
+	if err != nil {
+		return markers, opts, err
+	}
+
+	if len(locs) == 0 {
+		return markers, opts, nil
+	}
+
+	resolved := coordinates(locs)
+	b := NewBounds(locs...)
+	c := b.Center()
+	opts.Zoom = b.Zoom(opts.Size, DefaultPadding)
+	opts.Center = &c
+	opts.Path = resolved[len(markers):]
+
+	return resolved[:len(markers)], opts, nil
+}
+
+// resolve returns the markers as {lat},{lng}, for static maps which only
+// takes coordinates.
+func resolve(p Provider, markers []string) ([]string, error) {
+	locs, err := Locations(p, markers)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return coordinates(locs), nil
+}
+
+func coordinates(locs []Location) []string {
+	s := make([]string, len(locs))
+
+	for i, l := range locs {
+		s[i] = l.String()
+	}
+
+	return s
+}
@@ -8,8 +8,13 @@ import (
 )
 
 type (
+	googleViewport struct {
+		NorthEast Location `json:"northeast"`
+		SouthWest Location `json:"southwest"`
+	}
 	googleGeometry struct {
 		Location `json:"location"`
+		Viewport *googleViewport `json:"viewport"`
 	}
 	googleComponents struct {
 		Long  string   `json:"long_name"`
@@ -50,11 +55,29 @@ func (api *googleAPI) Address(address string) (Result, error) {
 }
 
 func (api *googleAPI) Image(address []string, opts MapOptions) ([]byte, error) {
+	address, opts, err := Fit(api, address, opts)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	format, err := NewFormat(opts.Format)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
 	qry := url.Values{}
 	qry.Add("key", api.APIKey)
 	qry.Add("markers", strings.Join(address, "|"))
 	qry.Add("size", opts.Size.String())
 
+	if len(opts.Path) > 0 {
+		qry.Add("path", strings.Join(opts.Path, "|"))
+	}
+	if opts.Center != nil {
+		qry.Add("center", opts.Center.String())
+	}
 	if opts.Zoom > 0 {
 		qry.Add("zoom", fmt.Sprintf("%v", opts.Zoom))
 	}
@@ -62,8 +85,19 @@ func (api *googleAPI) Image(address []string, opts MapOptions) ([]byte, error) {
 		qry.Add("scale", fmt.Sprintf("%v", opts.Scale))
 	}
 
+	// webp isn't supported by google, and will be transcoded from png.
+	if format != "webp" {
+		qry.Add("format", format)
+	}
+
 	url := fmt.Sprintf("%s?%s", api.Img, qry.Encode())
-	return fetch(api.Fetcher, url)
+	b, err := fetch(api.Fetcher, url)
+
+	if err != nil {
+		return b, err
+	}
+
+	return Transcode(b, format)
 }
 
 func (api *googleAPI) googleGeoService(url, qry string) (res Result, err error) {
@@ -103,8 +137,14 @@ func (r googleResults) toGeoResult(qry string) Result {
 	// 	log.Println("-", key, "=>", val)
 	// }
 
+	var bounds *Bounds
+	if vp := first.Geometry.Viewport; vp != nil {
+		bounds = &Bounds{SouthWest: vp.SouthWest, NorthEast: vp.NorthEast}
+	}
+
 	return Result{
 		Query:    qry,
+		Provider: "google",
 		Street:   street,
 		Country:  dic["country"],
 		City:     dic["locality"],
@@ -112,5 +152,6 @@ func (r googleResults) toGeoResult(qry string) Result {
 		State:    state,
 		Location: first.Geometry.Location,
 		Address:  first.Address,
+		Bounds:   bounds,
 	}
 }
@@ -0,0 +1,126 @@
+package geo
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+)
+
+// DefaultFormat of static images, if nothing is specificed in the request
+const DefaultFormat = "png"
+
+var (
+	// contentTypes of the supported static image formats
+	contentTypes = map[string]string{
+		"png":  "image/png",
+		"jpg":  "image/jpeg",
+		"gif":  "image/gif",
+		"webp": "image/webp",
+	}
+	// decoded maps the format names used by image.Decode to ours
+	decoded = map[string]string{
+		"png":  "png",
+		"jpeg": "jpg",
+		"gif":  "gif",
+		"webp": "webp",
+	}
+)
+
+// Formats returns a list of the supported static image formats.
+func Formats() []string {
+	return []string{"png", "jpg", "gif", "webp"}
+}
+
+// NewFormat converts a format name or file extension, eg. "JPEG" or ".jpg",
+// to one of the supported image formats.
+func NewFormat(format string) (string, error) {
+	format = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(format)), ".")
+
+	switch format {
+	case "":
+		return DefaultFormat, nil
+	case "jpeg":
+		return "jpg", nil
+	}
+
+	if _, ok := contentTypes[format]; !ok {
+		return "", fmt.Errorf("unsupported image format: '%s'", format)
+	}
+
+	return format, nil
+}
+
+// ContentType returns the mime type of the image format.
+func ContentType(format string) string {
+	if f, err := NewFormat(format); err == nil {
+		return contentTypes[f]
+	}
+
+	return "application/octet-stream"
+}
+
+// Transcode converts the image b to format, if it isn't already.
+func Transcode(b []byte, format string) ([]byte, error) {
+	format, err := NewFormat(format)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	_, current, err := image.DecodeConfig(bytes.NewReader(b))
+
+	if err != nil {
+		return []byte{}, fmt.Errorf("decoding image: %v", err)
+	}
+
+	if decoded[current] == format {
+		return b, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(b))
+
+	if err != nil {
+		return []byte{}, fmt.Errorf("decoding image: %v", err)
+	}
+
+	return encodeImage(img, format)
+}
+
+// encodeImage encodes img using the image format.
+func encodeImage(img image.Image, format string) ([]byte, error) {
+	format, err := NewFormat(format)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	var buf bytes.Buffer
+
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpg":
+		err = jpeg.Encode(&buf, opaque(img), &jpeg.Options{Quality: 90})
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	case "webp":
+		err = encodeWebP(&buf, img)
+	default:
+		err = fmt.Errorf("encoding %s images is not supported", format)
+	}
+
+	return buf.Bytes(), err
+}
+
+// opaque flattens img on a white background, as jpeg has no alpha channel.
+func opaque(img image.Image) image.Image {
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.White, image.ZP, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
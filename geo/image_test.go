@@ -0,0 +1,51 @@
+package geo
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFormat(t *testing.T) {
+	for in, expected := range map[string]string{
+		"": "png", "png": "png", ".PNG": "png", "jpeg": "jpg", ".jpg": "jpg", "gif": "gif", "webp": "webp",
+	} {
+		f, err := NewFormat(in)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, f)
+	}
+
+	_, err := NewFormat("bmp")
+	assert.Equal(t, "unsupported image format: 'bmp'", err.Error())
+
+	assert.Equal(t, "image/jpeg", ContentType("jpeg"))
+	assert.Equal(t, "image/webp", ContentType("webp"))
+}
+
+func TestTranscode(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 10, 10))))
+
+	b, err := Transcode(buf.Bytes(), "png")
+	assert.Nil(t, err)
+	assert.Equal(t, buf.Bytes(), b)
+
+	for format, name := range map[string]string{"jpg": "jpeg", "gif": "gif"} {
+		b, err = Transcode(buf.Bytes(), format)
+		assert.Nil(t, err)
+		_, decoded, err := image.DecodeConfig(bytes.NewReader(b))
+		assert.Nil(t, err)
+		assert.Equal(t, name, decoded)
+	}
+
+	b, err = Transcode(buf.Bytes(), "webp")
+	assert.Nil(t, err)
+	assert.Equal(t, "RIFF", string(b[0:4]))
+	assert.Equal(t, "WEBPVP8L", string(b[8:16]))
+
+	_, err = Transcode([]byte("not an image"), "png")
+	assert.NotNil(t, err)
+}
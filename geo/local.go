@@ -0,0 +1,281 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	markerColor     = color.RGBA{0xd9, 0x30, 0x25, 0xff}
+	markerBorder    = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	pathColor       = color.RGBA{0x1a, 0x73, 0xe8, 0xff}
+	backgroundColor = color.RGBA{0xe5, 0xe3, 0xdf, 0xff}
+)
+
+type (
+	// tileSource returns the decoded web mercator tile at z/x/y.
+	tileSource interface {
+		Tile(z, x, y int) (image.Image, error)
+	}
+	// dirTiles is a tile source laid out as {dir}/{z}/{x}/{y}.png
+	dirTiles string
+	localAPI struct {
+		Tiles string
+		Config
+
+		once sync.Once
+		src  tileSource
+		err  error
+	}
+)
+
+func (api *localAPI) Location(loc Location) (Result, error) {
+	if err := loc.Valid(); err != nil {
+		return Result{}, err
+	}
+
+	// there is no offline address database, so only the location is known.
+	return Result{Query: loc.String(), Provider: "local", Location: loc}, nil
+}
+
+func (api *localAPI) Address(address string) (Result, error) {
+	loc, err := NewLocation(address)
+
+	if err != nil {
+		return Result{}, fmt.Errorf("address lookup not supported offline: %s", address)
+	}
+
+	return Result{Query: address, Provider: "local", Location: loc}, nil
+}
+
+func (api *localAPI) Image(markers []string, opts MapOptions) ([]byte, error) {
+	// the canvas is allocated up front, so it's size is checked first.
+	if err := opts.Valid(); err != nil {
+		return []byte{}, err
+	}
+
+	src, err := api.tiles()
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	if opts.Size.Width == 0 || opts.Size.Height == 0 {
+		opts.Size = DefaultSize
+	}
+
+	if markers, opts, err = Fit(api, markers, opts); err != nil {
+		return []byte{}, err
+	}
+
+	locs, err := parseLocations(markers)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	path, err := parseLocations(opts.Path)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	zoom, c := opts.Zoom, Location{}
+
+	if opts.Center != nil {
+		c = *opts.Center
+	} else {
+		c = NewBounds(append(locs, path...)...).Center()
+	}
+
+	// scale renders the same area with more pixels by going one zoom level
+	// deeper for each doubling.
+	if opts.Scale > 1 {
+		zoom += uint64(math.Log2(float64(opts.Scale)))
+		opts.Size.Width *= opts.Scale
+		opts.Size.Height *= opts.Scale
+	}
+
+	img := renderMap(src, c, zoom, opts.Size)
+	origin := mapOrigin(c, zoom, opts.Size)
+
+	drawPath(img, path, zoom, origin)
+
+	for _, l := range locs {
+		drawMarker(img, wrap(project(l, zoom), project(c, zoom).X, zoom), origin)
+	}
+
+	return encodeImage(img, opts.Format)
+}
+
+// tiles opens the tile source once, so a mbtiles file is only indexed
+// the first time it is used.
+func (api *localAPI) tiles() (tileSource, error) {
+	api.once.Do(func() {
+		api.src, api.err = openTiles(api.Tiles)
+	})
+
+	return api.src, api.err
+}
+
+func (d dirTiles) Tile(z, x, y int) (image.Image, error) {
+	for _, ext := range []string{".png", ".jpg", ".jpeg"} {
+		name := filepath.Join(string(d),
+			fmt.Sprint(z), fmt.Sprint(x), fmt.Sprint(y)+ext)
+
+		f, err := os.Open(name)
+
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		img, _, err := image.Decode(f)
+		f.Close()
+		return img, err
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// openTiles returns the tile source located at path.
+func openTiles(path string) (tileSource, error) {
+	if len(path) == 0 {
+		return nil, errors.New("no tile source, use --tiles or GOGEO_TILES")
+	}
+
+	if strings.HasSuffix(strings.ToLower(path), ".mbtiles") {
+		return openMBTiles(path)
+	}
+
+	fi, err := os.Stat(path)
+
+	if err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("tile source: %s is not a directory", path)
+	}
+
+	return dirTiles(path), nil
+}
+
+func parseLocations(markers []string) ([]Location, error) {
+	locs := make([]Location, 0, len(markers))
+
+	for _, m := range markers {
+		l, err := NewLocation(m)
+
+		if err != nil {
+			return nil, fmt.Errorf("marker '%s': %v", m, err)
+		}
+
+		locs = append(locs, l)
+	}
+
+	return locs, nil
+}
+
+// mapOrigin returns the pixel of the top-left corner of a map of size
+// centered at c.
+func mapOrigin(c Location, zoom uint64, size Size) point {
+	p := project(c, zoom)
+	return point{
+		X: math.Floor(p.X - float64(size.Width)/2),
+		Y: math.Floor(p.Y - float64(size.Height)/2),
+	}
+}
+
+// renderMap composes the tiles covering a map of size centered at c.
+// Missing tiles are left with the background color.
+func renderMap(src tileSource, c Location, zoom uint64, size Size) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, int(size.Width), int(size.Height)))
+	draw.Draw(img, img.Bounds(), image.NewUniform(backgroundColor), image.ZP, draw.Src)
+
+	origin := mapOrigin(c, zoom, size)
+	tiles := int(math.Exp2(float64(zoom)))
+
+	minX := int(math.Floor(origin.X / tileSize))
+	minY := int(math.Floor(origin.Y / tileSize))
+	maxX := int(math.Floor((origin.X + float64(size.Width)) / tileSize))
+	maxY := int(math.Floor((origin.Y + float64(size.Height)) / tileSize))
+
+	for ty := minY; ty <= maxY; ty++ {
+		if ty < 0 || ty >= tiles {
+			continue
+		}
+
+		for tx := minX; tx <= maxX; tx++ {
+			// wrap around the antimeridian
+			x := ((tx % tiles) + tiles) % tiles
+			tile, err := src.Tile(int(zoom), x, ty)
+
+			if err != nil {
+				continue
+			}
+
+			at := image.Pt(tx*tileSize-int(origin.X), ty*tileSize-int(origin.Y))
+			r := image.Rectangle{at, at.Add(image.Pt(tileSize, tileSize))}
+			draw.Draw(img, r, tile, tile.Bounds().Min, draw.Over)
+		}
+	}
+
+	return img
+}
+
+// drawMarker draws a filled circle with a white border at p.
+func drawMarker(img *image.RGBA, p, origin point) {
+	cx, cy := p.X-origin.X, p.Y-origin.Y
+	fillCircle(img, cx, cy, 7, markerBorder)
+	fillCircle(img, cx, cy, 5, markerColor)
+}
+
+// drawPath draws a line between each of the locations, the shortest way
+// around the world.
+func drawPath(img *image.RGBA, path []Location, zoom uint64, origin point) {
+	a := point{X: origin.X + float64(img.Bounds().Dx())/2}
+
+	for i, l := range path {
+		b := wrap(project(l, zoom), a.X, zoom)
+
+		if i > 0 {
+			drawLine(img, a.X-origin.X, a.Y-origin.Y, b.X-origin.X, b.Y-origin.Y, 3, pathColor)
+		}
+
+		a = b
+	}
+}
+
+func drawLine(img *image.RGBA, x0, y0, x1, y1, width float64, c color.Color) {
+	steps := math.Max(math.Abs(x1-x0), math.Abs(y1-y0))
+
+	for i := 0.0; i <= steps; i++ {
+		t := 0.0
+		if steps > 0 {
+			t = i / steps
+		}
+		fillCircle(img, x0+(x1-x0)*t, y0+(y1-y0)*t, width/2, c)
+	}
+}
+
+func fillCircle(img *image.RGBA, cx, cy, r float64, c color.Color) {
+	b := img.Bounds()
+
+	for y := int(cy - r); y <= int(cy+r); y++ {
+		for x := int(cx - r); x <= int(cx+r); x++ {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+
+			if dx*dx+dy*dy <= r*r && image.Pt(x, y).In(b) {
+				img.Set(x, y, c)
+			}
+		}
+	}
+}
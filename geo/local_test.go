@@ -0,0 +1,111 @@
+package geo
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBounds(t *testing.T) {
+	size := Size{Width: 400, Height: 300}
+	copenhagen := Location{Latitude: 55.676, Longitude: 12.568}
+	aarhus := Location{Latitude: 56.162, Longitude: 10.203}
+
+	b := NewBounds(copenhagen, aarhus)
+	assert.Equal(t, Location{Latitude: 55.676, Longitude: 10.203}, b.SouthWest)
+	assert.Equal(t, Location{Latitude: 56.162, Longitude: 12.568}, b.NorthEast)
+	assert.Equal(t, uint64(7), b.Zoom(size, DefaultPadding))
+	assert.Equal(t, uint64(defaultZoom), NewBounds(copenhagen).Zoom(size, DefaultPadding))
+
+	c := NewBounds(copenhagen).Center()
+	assert.InDelta(t, copenhagen.Latitude, c.Latitude, 0.0001)
+	assert.InDelta(t, copenhagen.Longitude, c.Longitude, 0.0001)
+}
+
+func TestBoundsAntimeridian(t *testing.T) {
+	size := Size{Width: 400, Height: 300}
+	fiji := Location{Latitude: -17.7, Longitude: 178.0}
+	samoa := Location{Latitude: -13.8, Longitude: -172.1}
+
+	// the narrow box is across the antimeridian, not around the world
+	b := NewBounds(fiji, samoa)
+	assert.True(t, b.CrossesAntimeridian())
+	assert.Equal(t, Location{Latitude: -17.7, Longitude: 178.0}, b.SouthWest)
+	assert.Equal(t, Location{Latitude: -13.8, Longitude: -172.1}, b.NorthEast)
+	assert.Equal(t, uint64(5), b.Zoom(size, DefaultPadding))
+	assert.InDelta(t, -177.05, b.Center().Longitude, 0.001)
+
+	// the same span, without the antimeridian in between, zooms the same
+	shifted := NewBounds(Location{Latitude: -17.7, Longitude: 8.0}, Location{Latitude: -13.8, Longitude: 17.9})
+	assert.False(t, shifted.CrossesAntimeridian())
+	assert.Equal(t, b.Zoom(size, DefaultPadding), shifted.Zoom(size, DefaultPadding))
+
+	// a center exactly on the antimeridian is -180
+	c := NewBounds(Location{Longitude: 170}, Location{Longitude: -170}).Center()
+	assert.InDelta(t, -180, c.Longitude, 0.0001)
+
+	// a viewport from a provider can cross it too
+	vp := Bounds{SouthWest: Location{Latitude: 50, Longitude: 179}, NorthEast: Location{Latitude: 51, Longitude: -179}}
+	assert.True(t, vp.Zoom(size, DefaultPadding) > 6)
+}
+
+func TestFit(t *testing.T) {
+	p, _ := New("local")
+
+	markers, opts, err := Fit(p, []string{"55.676,12.568", "56.162,10.203"}, MapOptions{Size: Size{Width: 400, Height: 300}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"55.676,12.568", "56.162,10.203"}, markers)
+	assert.Equal(t, uint64(7), opts.Zoom)
+	assert.InDelta(t, 55.92, opts.Center.Latitude, 0.01)
+	assert.InDelta(t, 11.3855, opts.Center.Longitude, 0.0001)
+
+	// an explicit zoom is left untouched
+	_, opts, err = Fit(p, []string{"55.676,12.568"}, MapOptions{Zoom: 3})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), opts.Zoom)
+	assert.Nil(t, opts.Center)
+
+	_, _, err = Fit(p, []string{"vigerslev alle 77"}, DefaultMapOptions)
+	assert.NotNil(t, err)
+}
+
+func TestLocalImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gogeo")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// only the north-west tile at zoom 1 exists
+	tile := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+	for i := range tile.Pix {
+		tile.Pix[i] = []uint8{0, 0xff, 0, 0xff}[i%4]
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, png.Encode(&buf, tile))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "1", "0"), os.ModePerm))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "1", "0", "0.png"), buf.Bytes(), os.ModePerm))
+
+	p, _ := New("local", Config{Tiles: dir})
+	b, err := p.Image([]string{"0,0"}, MapOptions{Size: Size{Width: 100, Height: 80}, Zoom: 1})
+	assert.Nil(t, err)
+
+	img, err := png.Decode(bytes.NewReader(b))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 100, 80), img.Bounds())
+	assert.Equal(t, color.RGBAModel.Convert(markerColor), color.RGBAModel.Convert(img.At(50, 40)))
+	assert.Equal(t, color.RGBAModel.Convert(color.RGBA{0, 0xff, 0, 0xff}), color.RGBAModel.Convert(img.At(2, 2)))
+	assert.Equal(t, color.RGBAModel.Convert(backgroundColor), color.RGBAModel.Convert(img.At(98, 78)))
+
+	_, err = p.Image([]string{"vigerslev alle 77"}, DefaultMapOptions)
+	assert.NotNil(t, err)
+
+	// the size is checked before the canvas is allocated
+	_, err = p.Image([]string{"0,0"}, MapOptions{Size: Size{Width: 100000, Height: 100000}})
+	assert.EqualError(t, err, "size 100000x100000 out of range, max 640x640")
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"strings"
 )
 
 type (
@@ -35,6 +36,7 @@ type (
 func (api *mapquestAPI) Location(loc Location) (Result, error) {
 	qry := url.Values{}
 	qry.Add("location", loc.String())
+	api.addKey(qry)
 
 	url := fmt.Sprintf("%s%s?%s", api.Geo, "reverse", qry.Encode())
 	return api.toProviderResult(url, loc.String())
@@ -45,13 +47,75 @@ func (api *mapquestAPI) Address(address string) (Result, error) {
 	qry.Add("location", address)
 	qry.Add("maxResults", "1")
 	qry.Add("thumbMaps", "false")
+	api.addKey(qry)
 
 	url := fmt.Sprintf("%s%s?%s", api.Geo, "address", qry.Encode())
 	return api.toProviderResult(url, address)
 }
 
-func (api *mapquestAPI) Image(markers []string, options MapOptions) ([]byte, error) {
-	return []byte{}, fmt.Errorf("not implemeted")
+func (api *mapquestAPI) Image(markers []string, opts MapOptions) ([]byte, error) {
+	markers, opts, err := Fit(api, markers, opts)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	format, err := NewFormat(opts.Format)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	// the shape of the path is coordinates, which it already is if the map
+	// was fitted.
+	if opts.Path, err = resolve(api, opts.Path); err != nil {
+		return []byte{}, err
+	}
+
+	size := fmt.Sprintf("%v,%v", opts.Size.Width, opts.Size.Height)
+
+	if opts.Scale > 1 {
+		size += "@2x"
+	}
+
+	qry := url.Values{}
+	qry.Add("size", size)
+
+	if len(markers) > 0 {
+		qry.Add("locations", strings.Join(markers, "||"))
+	}
+	if len(opts.Path) > 0 {
+		qry.Add("shape", strings.Join(opts.Path, "|"))
+	}
+	if opts.Center != nil {
+		qry.Add("center", opts.Center.String())
+	}
+	if opts.Zoom > 0 {
+		qry.Add("zoom", fmt.Sprintf("%v", opts.Zoom))
+	}
+	api.addKey(qry)
+
+	// webp isn't supported by mapquest, and will be transcoded from png.
+	if format == "webp" {
+		qry.Add("format", "png")
+	} else {
+		qry.Add("format", format)
+	}
+
+	b, err := fetch(api.Fetcher, fmt.Sprintf("%s?%s", api.Img, qry.Encode()))
+
+	if err != nil {
+		return b, err
+	}
+
+	return Transcode(b, format)
+}
+
+// addKey adds the api key to the query, if one is configured.
+func (api *mapquestAPI) addKey(qry url.Values) {
+	if len(api.APIKey) > 0 {
+		qry.Add("key", api.APIKey)
+	}
 }
 
 func (api *mapquestAPI) toProviderResult(url, qry string) (res Result, err error) {
@@ -67,7 +131,8 @@ func (api *mapquestAPI) toProviderResult(url, qry string) (res Result, err error
 	l := p.Results[0].Location[0]
 	// addr := "muuha..."
 	return Result{
-		Query: qry,
+		Query:    qry,
+		Provider: "mapquest",
 		// Address:  addr,
 		Street:   l.Street,
 		Country:  l.Country,
@@ -0,0 +1,856 @@
+package geo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// mbtiles is a read-only tile source backed by a MBTiles (sqlite) file.
+// Only the parts of the sqlite file format needed to read rowid tables and
+// their indexes are implemented. Tiles are looked up when requested, by
+// the index on zoom_level, tile_column and tile_row the MBTiles spec
+// suggests, or by scanning the table if there's no such index. The tiles
+// are either a `tiles` table, or the `tiles` view of mbutil and TileMill,
+// which joins the `map` and `images` tables on tile_id. Other views, and
+// WITHOUT ROWID tables, aren't supported.
+type (
+	mbtiles struct {
+		f        *os.File
+		size     int64
+		pageSize int
+		usable   int
+		// tiles is the tiles table, or the map table of the tiles view,
+		// which points at the images table by tile_id.
+		tiles  table
+		images *table
+	}
+	// table is a rowid table, and the index on its key columns, if any
+	table struct {
+		root  uint32
+		index uint32
+		// cols are the positions of the key columns, followed by the
+		// tile_data or tile_id column.
+		cols []int
+	}
+	// schemaEntry is a table, view or index of the sqlite_master table
+	schemaEntry struct {
+		typ   string
+		table string
+		root  uint32
+		sql   string
+	}
+)
+
+// maxDepth bounds the b-tree depth, which is only a few pages in any real
+// file.
+const maxDepth = 32
+
+// errFound stops a scan once the row is found.
+var errFound = errors.New("found")
+
+func openMBTiles(path string) (tileSource, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	db := &mbtiles{f: f}
+
+	if err = db.open(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("mbtiles: %v", err)
+	}
+
+	return db, nil
+}
+
+func (db *mbtiles) Tile(z, x, y int) (image.Image, error) {
+	// mbtiles uses the tms scheme, where rows are counted from the bottom.
+	t := db.tiles
+	row, err := db.lookup(t, int64(z), int64(x), int64((1<<uint(z))-1-y))
+
+	if db.images != nil && row != nil {
+		// the map table points at the images table by tile_id, a NULL
+		// tile_id never joins.
+		id := column(row, t.cols[3])
+		t, row = *db.images, nil
+
+		if id != nil {
+			row, err = db.lookup(t, id)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if row == nil {
+		return nil, os.ErrNotExist
+	}
+
+	b, ok := column(row, t.cols[len(t.cols)-1]).([]byte)
+
+	if !ok {
+		return nil, errors.New("mbtiles: tile_data is not a blob")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(b))
+	return img, err
+}
+
+// open reads the sqlite header, and finds the tiles in the schema.
+func (db *mbtiles) open() error {
+	fi, err := db.f.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	hdr := make([]byte, 100)
+
+	if _, err := db.f.ReadAt(hdr, 0); err == io.EOF {
+		return errors.New("not a sqlite database")
+	} else if err != nil {
+		return err
+	}
+
+	if string(hdr[:16]) != "SQLite format 3\x00" {
+		return errors.New("not a sqlite database")
+	}
+
+	db.size = fi.Size()
+	db.pageSize = int(binary.BigEndian.Uint16(hdr[16:18]))
+
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+
+	db.usable = db.pageSize - int(hdr[20])
+
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 || db.usable < 480 {
+		return fmt.Errorf("invalid page size %d", db.pageSize)
+	}
+
+	schema, err := db.schema()
+
+	if err != nil {
+		return err
+	}
+
+	tiles, ok := schema["tiles"]
+
+	switch {
+	case !ok:
+		return errors.New("no tiles table")
+	case tiles.typ == "view":
+		return db.openView(schema)
+	case tiles.typ != "table":
+		return errors.New("tiles is a " + tiles.typ + ", only tables and views are supported")
+	}
+
+	db.tiles, err = newTable(schema, "tiles", "zoom_level", "tile_column", "tile_row", "tile_data")
+	return err
+}
+
+// openView finds the tables of the tiles view of mbutil and TileMill, where
+// the map table points at the tile data in the images table by tile_id.
+func (db *mbtiles) openView(schema map[string]schemaEntry) error {
+	if schema["map"].typ != "table" || schema["images"].typ != "table" {
+		return errors.New("tiles is a view, only the map and images tables of mbutil are supported")
+	}
+
+	images, err := newTable(schema, "images", "tile_id", "tile_data")
+
+	if err != nil {
+		return err
+	}
+
+	db.images = &images
+	db.tiles, err = newTable(schema, "map", "zoom_level", "tile_column", "tile_row", "tile_id")
+	return err
+}
+
+// newTable finds the named columns of the table, and an index on the key
+// columns, all but the last of them.
+func newTable(schema map[string]schemaEntry, name string, cols ...string) (table, error) {
+	pos, err := parseColumns(schema[name].sql, cols...)
+
+	if err != nil {
+		return table{}, fmt.Errorf("%s: %v", name, err)
+	}
+
+	t := table{root: schema[name].root, cols: pos}
+
+indexes:
+	for _, e := range schema {
+		sql := strings.ToLower(e.sql)
+
+		// partial, descending and collated indexes aren't in the order
+		// the keys are compared in.
+		if e.typ != "index" || e.table != name || strings.Contains(sql, " where ") ||
+			strings.Contains(sql, " desc") || strings.Contains(sql, " collate") {
+			continue
+		}
+
+		idx, err := parseColumns(e.sql, cols[:len(cols)-1]...)
+
+		if err != nil {
+			continue
+		}
+
+		for i, c := range idx {
+			if c != i {
+				continue indexes
+			}
+		}
+
+		t.index = e.root
+	}
+
+	return t, nil
+}
+
+// lookup returns the row of the table, whose key columns equal key, or nil
+// if there's none.
+func (db *mbtiles) lookup(t table, key ...interface{}) ([]interface{}, error) {
+	if t.index != 0 {
+		rowid, ok, err := db.search(t.index, key)
+
+		if !ok || err != nil {
+			return nil, err
+		}
+
+		return db.row(t.root, rowid)
+	}
+
+	var row []interface{}
+
+	err := db.scan(t.root, func(v []interface{}) error {
+		for i, k := range key {
+			if compareValue(column(v, t.cols[i]), k) != 0 {
+				return nil
+			}
+		}
+
+		row = v
+		return errFound
+	})
+
+	if err == errFound {
+		err = nil
+	}
+
+	return row, err
+}
+
+// schema reads the tables, views and indexes of the sqlite_master table,
+// by their lower case name.
+func (db *mbtiles) schema() (map[string]schemaEntry, error) {
+	schema := map[string]schemaEntry{}
+
+	err := db.scan(1, func(v []interface{}) error {
+		typ, _ := column(v, 0).(string)
+		name, _ := column(v, 1).(string)
+		table, _ := column(v, 2).(string)
+		root, _ := column(v, 3).(int64)
+		sql, _ := column(v, 4).(string)
+
+		if typ == "table" || typ == "view" || typ == "index" {
+			schema[strings.ToLower(name)] = schemaEntry{typ, strings.ToLower(table), uint32(root), sql}
+		}
+
+		return nil
+	})
+
+	return schema, err
+}
+
+// scan calls fn with the decoded record of every row in the table rooted
+// at pgno.
+func (db *mbtiles) scan(pgno uint32, fn func(v []interface{}) error) error {
+	return db.walk(pgno, 0, map[uint32]bool{}, func(page []byte, offset int) error {
+		rec, err := db.payload(page, offset, false)
+
+		if err != nil {
+			return err
+		}
+
+		v, err := decodeRecord(rec)
+
+		if err != nil {
+			return err
+		}
+
+		return fn(v)
+	})
+}
+
+// walk calls fn for every leaf cell in the table b-tree rooted at pgno.
+func (db *mbtiles) walk(pgno uint32, depth int, seen map[uint32]bool, fn func(page []byte, offset int) error) error {
+	if err := visit(seen, pgno, depth); err != nil {
+		return err
+	}
+
+	page, typ, offsets, right, err := db.cells(pgno)
+
+	if err != nil {
+		return err
+	}
+
+	switch typ {
+	case 0x0d: // leaf table
+		for _, offset := range offsets {
+			if err := fn(page, offset); err != nil {
+				return err
+			}
+		}
+	case 0x05: // interior table
+		for _, offset := range offsets {
+			left, err := child(page, pgno, offset)
+
+			if err != nil {
+				return err
+			}
+
+			if err := db.walk(left, depth+1, seen, fn); err != nil {
+				return err
+			}
+		}
+		return db.walk(right, depth+1, seen, fn)
+	default:
+		return fmt.Errorf("page %d: not a table b-tree page", pgno)
+	}
+
+	return nil
+}
+
+// row returns the row with the rowid, of the table b-tree rooted at pgno,
+// or nil if there's none.
+func (db *mbtiles) row(pgno uint32, rowid int64) ([]interface{}, error) {
+	seen := map[uint32]bool{}
+
+	for depth := 0; ; depth++ {
+		if err := visit(seen, pgno, depth); err != nil {
+			return nil, err
+		}
+
+		page, typ, offsets, next, err := db.cells(pgno)
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch typ {
+		case 0x0d: // leaf table
+			for _, offset := range offsets {
+				if offset >= len(page) {
+					return nil, fmt.Errorf("page %d: corrupt cell", pgno)
+				}
+
+				_, n := uvarint(page[offset:]) // payload size
+
+				if id, _ := uvarint(page[offset+n:]); int64(id) != rowid {
+					continue
+				}
+
+				rec, err := db.payload(page, offset, false)
+
+				if err != nil {
+					return nil, err
+				}
+
+				return decodeRecord(rec)
+			}
+
+			return nil, nil
+		case 0x05: // interior table
+			for _, offset := range offsets {
+				left, err := child(page, pgno, offset)
+
+				if err != nil {
+					return nil, err
+				}
+
+				// the left child holds the rows up to, and including, the key
+				if key, _ := uvarint(page[offset+4:]); rowid <= int64(key) {
+					next = left
+					break
+				}
+			}
+		default:
+			return nil, fmt.Errorf("page %d: not a table b-tree page", pgno)
+		}
+
+		pgno = next
+	}
+}
+
+// search returns the rowid of an entry, which starts with key, of the
+// index b-tree rooted at pgno.
+func (db *mbtiles) search(pgno uint32, key []interface{}) (int64, bool, error) {
+	seen := map[uint32]bool{}
+
+	for depth := 0; ; depth++ {
+		if err := visit(seen, pgno, depth); err != nil {
+			return 0, false, err
+		}
+
+		page, typ, offsets, next, err := db.cells(pgno)
+
+		if err != nil {
+			return 0, false, err
+		}
+
+		if typ != 0x0a && typ != 0x02 {
+			return 0, false, fmt.Errorf("page %d: not an index b-tree page", pgno)
+		}
+
+		for _, offset := range offsets {
+			var left uint32
+
+			// interior cells start with their left child, which holds
+			// the entries before the cell's own.
+			if typ == 0x02 {
+				if left, err = child(page, pgno, offset); err != nil {
+					return 0, false, err
+				}
+
+				offset += 4
+			}
+
+			rec, err := db.payload(page, offset, true)
+
+			if err != nil {
+				return 0, false, err
+			}
+
+			v, err := decodeRecord(rec)
+
+			if err != nil {
+				return 0, false, err
+			}
+
+			c := compareKey(v, key)
+
+			if c == 0 {
+				// the rowid follows the indexed columns
+				rowid, ok := column(v, len(v)-1).(int64)
+
+				if !ok || len(v) <= len(key) {
+					return 0, false, fmt.Errorf("page %d: corrupt index entry", pgno)
+				}
+
+				return rowid, true, nil
+			}
+
+			if c > 0 {
+				next = left
+				break
+			}
+		}
+
+		if typ == 0x0a {
+			return 0, false, nil
+		}
+
+		pgno = next
+	}
+}
+
+// visit marks the page as seen. A page is only ever part of a single
+// b-tree once, so a repeated page, or one deeper than maxDepth, is a
+// corrupt file.
+func visit(seen map[uint32]bool, pgno uint32, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("page %d: b-tree too deep", pgno)
+	}
+
+	if seen[pgno] {
+		return fmt.Errorf("page %d: repeated in the b-tree", pgno)
+	}
+
+	seen[pgno] = true
+	return nil
+}
+
+// cells reads the b-tree page pgno, and returns its type, the offsets of
+// its cells and the right-most child of interior pages.
+func (db *mbtiles) cells(pgno uint32) (page []byte, typ byte, offsets []int, right uint32, err error) {
+	if page, err = db.page(pgno); err != nil {
+		return nil, 0, nil, 0, err
+	}
+
+	hdr := 0
+	if pgno == 1 {
+		hdr = 100
+	}
+
+	typ = page[hdr]
+	n := int(binary.BigEndian.Uint16(page[hdr+3:]))
+	ptrs := hdr + 8
+
+	switch typ {
+	case 0x02, 0x05: // interior index, interior table
+		right = binary.BigEndian.Uint32(page[hdr+8:])
+		ptrs += 4
+	case 0x0a, 0x0d: // leaf index, leaf table
+	default:
+		return nil, 0, nil, 0, fmt.Errorf("page %d: unsupported b-tree page type %#x", pgno, typ)
+	}
+
+	if ptrs+n*2 > len(page) {
+		return nil, 0, nil, 0, fmt.Errorf("page %d: corrupt cell count", pgno)
+	}
+
+	offsets = make([]int, n)
+
+	for i := range offsets {
+		offsets[i] = int(binary.BigEndian.Uint16(page[ptrs+i*2:]))
+	}
+
+	return page, typ, offsets, right, nil
+}
+
+// child returns the left child of the interior cell at offset.
+func child(page []byte, pgno uint32, offset int) (uint32, error) {
+	if offset+4 > len(page) {
+		return 0, fmt.Errorf("page %d: corrupt cell", pgno)
+	}
+
+	return binary.BigEndian.Uint32(page[offset:]), nil
+}
+
+func (db *mbtiles) page(pgno uint32) ([]byte, error) {
+	offset := (int64(pgno) - 1) * int64(db.pageSize)
+
+	if pgno == 0 || offset >= db.size {
+		return nil, fmt.Errorf("page %d: out of range", pgno)
+	}
+
+	b := make([]byte, db.pageSize)
+	_, err := db.f.ReadAt(b, offset)
+
+	if err == io.EOF {
+		err = nil
+	}
+
+	return b, err
+}
+
+// payload returns the complete record of the leaf table, or index, cell at
+// offset, following overflow pages if needed.
+func (db *mbtiles) payload(page []byte, offset int, index bool) ([]byte, error) {
+	if offset >= len(page) {
+		return nil, errors.New("corrupt cell")
+	}
+
+	size, n := uvarint(page[offset:])
+	offset += n
+
+	if offset >= len(page) || size > uint64(db.size) {
+		return nil, errors.New("corrupt cell")
+	}
+
+	// index cells have a smaller part of the payload on the page, and no
+	// rowid.
+	max := db.usable - 35
+
+	if index {
+		max = (db.usable-12)*64/255 - 23
+	} else {
+		_, n = uvarint(page[offset:]) // rowid
+		offset += n
+	}
+
+	local := db.localSize(int(size), max)
+
+	if offset+local > len(page) {
+		return nil, errors.New("corrupt cell")
+	}
+
+	rec := append([]byte{}, page[offset:offset+local]...)
+
+	if local == int(size) {
+		return rec, nil
+	}
+
+	if offset+local+4 > len(page) {
+		return nil, errors.New("corrupt cell")
+	}
+
+	next := binary.BigEndian.Uint32(page[offset+local:])
+
+	for len(rec) < int(size) {
+		if next == 0 {
+			return nil, errors.New("corrupt overflow chain")
+		}
+
+		overflow, err := db.page(next)
+
+		if err != nil {
+			return nil, err
+		}
+
+		n := int(size) - len(rec)
+
+		if n > db.usable-4 {
+			n = db.usable - 4
+		}
+
+		rec = append(rec, overflow[4:4+n]...)
+		next = binary.BigEndian.Uint32(overflow)
+	}
+
+	return rec, nil
+}
+
+// localSize is the number of payload bytes stored on the b-tree page
+// itself, max is the most a page can hold.
+func (db *mbtiles) localSize(size, max int) int {
+	if size <= max {
+		return size
+	}
+
+	min := (db.usable-12)*32/255 - 23
+	k := min + (size-min)%(db.usable-4)
+
+	if k <= max {
+		return k
+	}
+
+	return min
+}
+
+// decodeRecord decodes a sqlite record into int64, float64, string,
+// []byte or nil values.
+func decodeRecord(rec []byte) ([]interface{}, error) {
+	hdrSize, n := uvarint(rec)
+
+	if hdrSize > uint64(len(rec)) || int(hdrSize) < n {
+		return nil, errors.New("corrupt record")
+	}
+
+	var types []uint64
+
+	for i := n; i < int(hdrSize); {
+		t, n := uvarint(rec[i:])
+		types = append(types, t)
+		i += n
+	}
+
+	body := rec[hdrSize:]
+	values := make([]interface{}, len(types))
+
+	for i, t := range types {
+		size := serialSize(t)
+
+		if size < 0 || size > len(body) {
+			return nil, errors.New("corrupt record")
+		}
+
+		b := body[:size]
+		body = body[size:]
+
+		switch {
+		case t == 0:
+			values[i] = nil
+		case t <= 6:
+			v := int64(int8(b[0]))
+			for _, c := range b[1:] {
+				v = v<<8 | int64(c)
+			}
+			values[i] = v
+		case t == 7:
+			values[i] = math.Float64frombits(binary.BigEndian.Uint64(b))
+		case t == 8:
+			values[i] = int64(0)
+		case t == 9:
+			values[i] = int64(1)
+		case t >= 12 && t%2 == 0:
+			values[i] = b
+		case t >= 13:
+			values[i] = string(b)
+		default:
+			return nil, fmt.Errorf("unsupported serial type %d", t)
+		}
+	}
+
+	return values, nil
+}
+
+func serialSize(t uint64) int {
+	switch {
+	case t <= 4:
+		return []int{0, 1, 2, 3, 4}[t]
+	case t == 5:
+		return 6
+	case t == 6, t == 7:
+		return 8
+	case t < 12:
+		return 0
+	}
+
+	if t > math.MaxInt32 {
+		return -1
+	}
+
+	return int(t-12) / 2
+}
+
+// uvarint decodes a sqlite (big-endian) varint.
+func uvarint(b []byte) (v uint64, n int) {
+	for n = 0; n < 8 && n < len(b); n++ {
+		v = v<<7 | uint64(b[n]&0x7f)
+
+		if b[n] < 0x80 {
+			return v, n + 1
+		}
+	}
+
+	if n < len(b) {
+		return v<<8 | uint64(b[n]), n + 1
+	}
+
+	return v, n
+}
+
+// column returns the i'th value of the record, or nil if the row was
+// written before the column was added.
+func column(v []interface{}, i int) interface{} {
+	if i < len(v) {
+		return v[i]
+	}
+
+	return nil
+}
+
+// compareKey compares the first values of the record with key.
+func compareKey(v, key []interface{}) int {
+	for i, k := range key {
+		if c := compareValue(column(v, i), k); c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+// compareValue compares two values in sqlite's order, NULL before numbers,
+// text and blobs. Text is compared byte by byte, the BINARY collation.
+func compareValue(a, b interface{}) int {
+	rank := func(v interface{}) int {
+		switch v.(type) {
+		case int64, float64:
+			return 1
+		case string:
+			return 2
+		case []byte:
+			return 3
+		}
+		return 0
+	}
+
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return ra - rb
+	}
+
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	case nil:
+		return 0
+	}
+
+	// integers are only compared as floats with a real
+	x, xok := a.(int64)
+	y, yok := b.(int64)
+
+	switch {
+	case xok && yok && x < y:
+		return -1
+	case xok && yok:
+		return boolInt(x > y)
+	}
+
+	f, g := number(a), number(b)
+
+	if f < g {
+		return -1
+	}
+
+	return boolInt(f > g)
+}
+
+func number(v interface{}) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+
+	f, _ := v.(float64)
+	return f
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// parseColumns finds the positions of the named columns from the CREATE
+// TABLE sql.
+func parseColumns(sql string, names ...string) ([]int, error) {
+	start, end := strings.Index(sql, "("), strings.LastIndex(sql, ")")
+
+	if start < 0 || end < start {
+		return nil, errors.New("unable to parse schema")
+	}
+
+	cols := make([]int, len(names))
+
+	for i := range cols {
+		cols[i] = -1
+	}
+
+columns:
+	for i, def := range strings.Split(sql[start+1:end], ",") {
+		fields := strings.Fields(def)
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		name := strings.ToLower(strings.Trim(fields[0], "\"`[]"))
+
+		switch name {
+		case "primary", "unique", "check", "foreign", "constraint":
+			// table constraints always follow the column definitions
+			break columns
+		}
+
+		for j, n := range names {
+			if name == n {
+				cols[j] = i
+			}
+		}
+	}
+
+	for i, c := range cols {
+		if c < 0 {
+			return nil, fmt.Errorf("missing column %s", names[i])
+		}
+	}
+
+	return cols, nil
+}
@@ -0,0 +1,236 @@
+package geo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	red   = color.RGBA{0xff, 0, 0, 0xff}
+	green = color.RGBA{0, 0xff, 0, 0xff}
+)
+
+func TestMBTiles(t *testing.T) {
+	src, err := openTiles(filepath.Join("testdata", "tiles.mbtiles"))
+	assert.Nil(t, err)
+
+	for _, test := range []struct {
+		z, x, y int
+		color   color.Color
+	}{
+		{0, 0, 0, red},
+		// the rows are flipped, mbtiles counts them from the bottom
+		{1, 0, 0, green},
+		{3, 7, 7, red},
+		{3, 0, 0, red},
+	} {
+		img, err := src.Tile(test.z, test.x, test.y)
+		assert.Nil(t, err, test)
+		assert.Equal(t, test.color, color.RGBAModel.Convert(img.At(1, 1)), test)
+	}
+
+	// a tile larger than a page is stored on overflow pages
+	img, err := src.Tile(1, 1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 40, 40), img.Bounds())
+
+	_, err = src.Tile(1, 1, 0)
+	assert.Equal(t, os.ErrNotExist, err)
+	_, err = src.Tile(2, 0, 0)
+	assert.Equal(t, os.ErrNotExist, err)
+}
+
+func TestMBTilesView(t *testing.T) {
+	// the tiles view of mbutil, over the map and images tables
+	src, err := openTiles(filepath.Join("testdata", "view.mbtiles"))
+	assert.Nil(t, err)
+
+	for _, test := range []struct {
+		z, x, y int
+		color   color.Color
+	}{
+		{0, 0, 0, red},
+		{1, 0, 0, green},
+		// the same image as 0/0/0
+		{1, 0, 1, red},
+	} {
+		img, err := src.Tile(test.z, test.x, test.y)
+		assert.Nil(t, err, test)
+		assert.Equal(t, test.color, color.RGBAModel.Convert(img.At(1, 1)), test)
+	}
+
+	img, err := src.Tile(1, 1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 40, 40), img.Bounds())
+
+	// the map row points at a missing image
+	_, err = src.Tile(1, 1, 0)
+	assert.Equal(t, os.ErrNotExist, err)
+}
+
+func TestMBTilesCorrupt(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "tiles.mbtiles"))
+	assert.Nil(t, err)
+
+	dir, err := ioutil.TempDir("", "gogeo")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "corrupt.mbtiles")
+
+	for _, data := range [][]byte{nil, []byte("SQLite format 3"), []byte("not a sqlite database, but long enough to be read as one... not a sqlite database, but long enough")} {
+		assert.Nil(t, ioutil.WriteFile(path, data, 0644))
+		_, err = openTiles(path)
+		assert.NotNil(t, err)
+	}
+
+	_, err = openTiles(filepath.Join(dir, "missing.mbtiles"))
+	assert.True(t, os.IsNotExist(err))
+
+	// truncated in the middle of the tiles, which are only read when
+	// they're looked up.
+	assert.Nil(t, ioutil.WriteFile(path, b[:len(b)/2], 0644))
+	src, err := openTiles(path)
+	assert.Nil(t, err)
+	_, err = src.Tile(3, 7, 7)
+	assert.NotNil(t, err)
+	assert.NotEqual(t, os.ErrNotExist, err)
+	src.(*mbtiles).f.Close()
+
+	// a corrupt page header or cell pointer is an error, or a wrong
+	// tile, but never a panic. The fixture has 1024 byte pages.
+	for i := 100; i < len(b); i++ {
+		if i%1024 >= 64 {
+			continue
+		}
+
+		c := append([]byte{}, b...)
+		c[i] ^= 0xff
+		assert.Nil(t, ioutil.WriteFile(path, c, 0644))
+
+		src, err := openMBTiles(path)
+
+		if err != nil {
+			continue
+		}
+
+		for z := 0; z < 4; z++ {
+			for x := 0; x < 1<<uint(z); x++ {
+				for y := 0; y < 1<<uint(z); y++ {
+					src.Tile(z, x, y)
+				}
+			}
+		}
+
+		src.(*mbtiles).f.Close()
+	}
+}
+
+func TestParseColumns(t *testing.T) {
+	cols, err := parseColumns(`CREATE TABLE "tiles" ("zoom_level" integer, [tile_column] integer, tile_row integer, tile_data blob, PRIMARY KEY (zoom_level, tile_column, tile_row))`,
+		"zoom_level", "tile_column", "tile_row", "tile_data")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3}, cols)
+
+	_, err = parseColumns("CREATE TABLE tiles (zoom_level integer, tile_data blob)", "zoom_level", "tile_row")
+	assert.Equal(t, "missing column tile_row", err.Error())
+
+	_, err = parseColumns("CREATE TABLE tiles", "tile_data")
+	assert.NotNil(t, err)
+}
+
+func TestMBTilesIndex(t *testing.T) {
+	// the index and the table both have interior pages, and the rows
+	// weren't inserted in key order.
+	src, err := openMBTiles(filepath.Join("testdata", "index.mbtiles"))
+	assert.Nil(t, err)
+
+	db := src.(*mbtiles)
+	defer db.f.Close()
+
+	indexed := db.tiles
+	assert.NotEqual(t, uint32(0), indexed.index)
+	scanned := indexed
+	scanned.index = 0
+
+	for _, tbl := range []table{indexed, scanned} {
+		for z := 0; z < 5; z++ {
+			for x := 0; x < 1<<uint(z); x++ {
+				for y := 0; y < 1<<uint(z); y++ {
+					row, err := db.lookup(tbl, int64(z), int64(x), int64(y))
+					assert.Nil(t, err)
+					assert.Equal(t, []byte(fmt.Sprintf("%d/%d/%d", z, x, y)), column(row, tbl.cols[3]))
+				}
+			}
+		}
+
+		for _, key := range [][]interface{}{{int64(5), int64(0), int64(0)}, {int64(0), int64(1), int64(0)}, {int64(-1), int64(0), int64(0)}, {"0", int64(0), int64(0)}} {
+			row, err := db.lookup(tbl, key...)
+			assert.Nil(t, err)
+			assert.Nil(t, row, key)
+		}
+	}
+}
+
+func TestMBTilesCycle(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "tiles.mbtiles"))
+	assert.Nil(t, err)
+
+	dir, err := ioutil.TempDir("", "gogeo")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cycle.mbtiles")
+
+	// page 3 is the interior root page of the tiles table, with 7 cells
+	// and 1024 byte pages.
+	const root = 2 * 1024
+
+	for name, corrupt := range map[string]func(c []byte){
+		"cycle": func(c []byte) {
+			binary.BigEndian.PutUint32(c[root+8:], 3)
+		},
+		"repeated": func(c []byte) {
+			for i := 0; i < 7; i++ {
+				offset := binary.BigEndian.Uint16(c[root+12+i*2:])
+				binary.BigEndian.PutUint32(c[root+int(offset):], 8)
+			}
+		},
+	} {
+		c := append([]byte{}, b...)
+		corrupt(c)
+		assert.Nil(t, ioutil.WriteFile(path, c, 0644))
+
+		src, err := openMBTiles(path)
+		assert.Nil(t, err, name)
+
+		db := src.(*mbtiles)
+		scanned := db.tiles
+		scanned.index = 0
+
+		_, err = db.lookup(scanned, int64(9), int64(0), int64(0))
+		assert.Contains(t, fmt.Sprint(err), "repeated in the b-tree", name)
+
+		db.f.Close()
+	}
+
+	// the lookup by rowid follows the right-most child, back to the root
+	c := append([]byte{}, b...)
+	binary.BigEndian.PutUint32(c[root+8:], 3)
+	assert.Nil(t, ioutil.WriteFile(path, c, 0644))
+
+	src, err := openMBTiles(path)
+	assert.Nil(t, err)
+	defer src.(*mbtiles).f.Close()
+
+	_, err = src.Tile(3, 7, 0)
+	assert.Equal(t, "page 3: repeated in the b-tree", fmt.Sprint(err))
+}
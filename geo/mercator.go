@@ -0,0 +1,154 @@
+package geo
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// tileSize is the width and height of a web mercator tile in pixels
+	tileSize = 256
+	// maxZoom is the highest zoom level used when auto fitting markers
+	maxZoom = 18
+	// defaultZoom is used when there is nothing to fit, eg. a single marker
+	defaultZoom = 15
+)
+
+// point represents a web mercator pixel coordinate at a given zoom level.
+type point struct {
+	X, Y float64
+}
+
+// worldSize returns the width (and height) of the world in pixels at zoom.
+func worldSize(zoom uint64) float64 {
+	return tileSize * math.Exp2(float64(zoom))
+}
+
+// project converts a location to a pixel coordinate at zoom.
+func project(l Location, zoom uint64) point {
+	lat := math.Max(math.Min(l.Latitude, 85.05112878), -85.05112878)
+	sin := math.Sin(lat * math.Pi / 180)
+	ws := worldSize(zoom)
+
+	return point{
+		X: (l.Longitude + 180) / 360 * ws,
+		Y: (0.5 - math.Log((1+sin)/(1-sin))/(4*math.Pi)) * ws,
+	}
+}
+
+// unproject converts a pixel coordinate at zoom back to a location.
+func unproject(p point, zoom uint64) Location {
+	ws := worldSize(zoom)
+	n := math.Pi - 2*math.Pi*p.Y/ws
+
+	return Location{
+		Latitude:  180 / math.Pi * math.Atan(math.Sinh(n)),
+		Longitude: p.X/ws*360 - 180,
+	}
+}
+
+// Bounds represents the smallest area containing a set of locations. A
+// bounds crossing the antimeridian has a south-west longitude east of the
+// north-east longitude, like 170,-170.
+type Bounds struct {
+	SouthWest Location `json:"sw" xml:"sw" yaml:"sw"`
+	NorthEast Location `json:"ne" xml:"ne" yaml:"ne"`
+}
+
+// NewBounds returns the bounding box of the locations, which crosses the
+// antimeridian when that is the narrower box.
+func NewBounds(locs ...Location) (b Bounds) {
+	if len(locs) == 0 {
+		return
+	}
+
+	lngs := make([]float64, len(locs))
+
+	for i, l := range locs {
+		if i == 0 {
+			b.SouthWest.Latitude, b.NorthEast.Latitude = l.Latitude, l.Latitude
+		}
+
+		b.SouthWest.Latitude = math.Min(b.SouthWest.Latitude, l.Latitude)
+		b.NorthEast.Latitude = math.Max(b.NorthEast.Latitude, l.Latitude)
+		lngs[i] = l.Longitude
+	}
+
+	sort.Float64s(lngs)
+
+	// the box is everything but the widest gap between the longitudes,
+	// going around the world from the east most back to the west most.
+	b.SouthWest.Longitude, b.NorthEast.Longitude = lngs[0], lngs[len(lngs)-1]
+	gap := lngs[0] + 360 - lngs[len(lngs)-1]
+
+	for i := 1; i < len(lngs); i++ {
+		if d := lngs[i] - lngs[i-1]; d > gap {
+			gap = d
+			b.SouthWest.Longitude, b.NorthEast.Longitude = lngs[i], lngs[i-1]
+		}
+	}
+
+	return
+}
+
+// Empty reports whether the bounds is a single point.
+func (b Bounds) Empty() bool {
+	return b.SouthWest == b.NorthEast
+}
+
+// CrossesAntimeridian reports whether the bounds wraps around from 180 to
+// -180 longitude.
+func (b Bounds) CrossesAntimeridian() bool {
+	return b.SouthWest.Longitude > b.NorthEast.Longitude
+}
+
+// Center returns the location in the middle of the bounds, in web mercator
+// pixels rather than degrees so it matches the rendered map.
+func (b Bounds) Center() Location {
+	sw, ne := project(b.SouthWest, maxZoom), project(b.NorthEast, maxZoom)
+
+	if b.CrossesAntimeridian() {
+		ne.X += worldSize(maxZoom)
+	}
+
+	c := unproject(point{(sw.X + ne.X) / 2, (sw.Y + ne.Y) / 2}, maxZoom)
+
+	if c.Longitude >= 180 {
+		c.Longitude -= 360
+	}
+
+	return c
+}
+
+// Zoom returns the highest zoom level where the bounds fits inside size,
+// leaving padding pixels free on every side.
+func (b Bounds) Zoom(size Size, padding uint64) uint64 {
+	if b.Empty() {
+		return defaultZoom
+	}
+
+	w := float64(size.Width) - 2*float64(padding)
+	h := float64(size.Height) - 2*float64(padding)
+
+	for z := uint64(maxZoom); z > 0; z-- {
+		sw, ne := project(b.SouthWest, z), project(b.NorthEast, z)
+
+		if b.CrossesAntimeridian() {
+			ne.X += worldSize(z)
+		}
+
+		if ne.X-sw.X <= w && sw.Y-ne.Y <= h {
+			return z
+		}
+	}
+
+	return 0
+}
+
+// wrap moves p a whole world east or west, to the copy of p closest to x,
+// so markers east of the antimeridian are drawn next to those west of it.
+func wrap(p point, x float64, zoom uint64) point {
+	ws := worldSize(zoom)
+	p.X -= math.Floor((p.X-x)/ws+0.5) * ws
+	return p
+}
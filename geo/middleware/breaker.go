@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// ErrBreakerOpen is returned while the circuit breaker is open.
+var ErrBreakerOpen = errors.New("circuit breaker open")
+
+type (
+	// Breaker is a circuit breaker, which opens after Threshold failures
+	// in a row, and fails fast until Cooldown has passed. Then a single
+	// request is let through, which closes the breaker if it succeeds.
+	// A Threshold of 0 never opens. Network errors and 5xx responses are
+	// failures, requests canceled by the client are neither. It also
+	// keeps the error rate of the recent requests.
+	Breaker struct {
+		sync.Mutex
+		Threshold int
+		Cooldown  time.Duration
+
+		state    string
+		failures int
+		opened   time.Time
+		trial    bool
+		recent   []outcome
+		// now is time.Now, or a clock set by the tests.
+		now func() time.Time
+	}
+	outcome struct {
+		at     time.Time
+		failed bool
+	}
+)
+
+// recentWindow is the time and number of requests the error rate covers.
+const (
+	recentWindow = 5 * time.Minute
+	recentSize   = 100
+)
+
+// NewBreaker returns a closed breaker.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{Threshold: threshold, Cooldown: cooldown, state: BreakerClosed, now: time.Now}
+}
+
+func (b *Breaker) clock() time.Time {
+	if b.now == nil {
+		return time.Now()
+	}
+
+	return b.now()
+}
+
+// Fetcher returns f guarded by the breaker.
+func (b *Breaker) Fetcher(f geo.Fetcher) geo.Fetcher {
+	return geo.FetcherFunc(func(req *http.Request) (*http.Response, error) {
+		if !b.allow(b.clock()) {
+			return nil, ErrBreakerOpen
+		}
+
+		resp, err := f.Do(req)
+		class := ErrorClass(resp, err)
+
+		// a request the client canceled says nothing about the provider.
+		if class == "canceled" {
+			b.release()
+			return resp, err
+		}
+
+		b.record(b.clock(), class == "network" || class == "timeout" || class == "server_error")
+		return resp, err
+	})
+}
+
+func (b *Breaker) allow(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.opened) < b.Cooldown {
+			return false
+		}
+		b.state, b.trial = BreakerHalfOpen, true
+		return true
+	case BreakerHalfOpen:
+		// only the trial request is let through
+		if b.trial {
+			return false
+		}
+		b.trial = true
+	}
+
+	return true
+}
+
+func (b *Breaker) record(now time.Time, failed bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.recent = append(b.recent, outcome{at: now, failed: failed})
+	if len(b.recent) > recentSize {
+		b.recent = b.recent[len(b.recent)-recentSize:]
+	}
+
+	switch {
+	case !failed:
+		b.state, b.failures, b.trial = BreakerClosed, 0, false
+	case b.state == BreakerHalfOpen:
+		b.state, b.opened, b.trial = BreakerOpen, now, false
+	default:
+		b.failures++
+		if b.Threshold > 0 && b.failures >= b.Threshold {
+			b.state, b.opened = BreakerOpen, now
+		}
+	}
+}
+
+// release lets the next request be the trial, if the trial was canceled.
+func (b *Breaker) release() {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.state == BreakerHalfOpen {
+		b.trial = false
+	}
+}
+
+// State returns closed, open or half-open.
+func (b *Breaker) State() string {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.state == "" {
+		return BreakerClosed
+	}
+
+	return b.state
+}
+
+// ErrorRate returns the failed ratio and the number of the requests in
+// the last 5 minutes, up to the last 100 requests.
+func (b *Breaker) ErrorRate() (rate float64, requests int) {
+	b.Lock()
+	defer b.Unlock()
+
+	failed := 0
+	since := b.clock().Add(-recentWindow)
+
+	for _, o := range b.recent {
+		if o.at.After(since) {
+			requests++
+			if o.failed {
+				failed++
+			}
+		}
+	}
+
+	if requests > 0 {
+		rate = float64(failed) / float64(requests)
+	}
+
+	return
+}
@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+func TestBreaker(t *testing.T) {
+	type step struct {
+		at time.Duration
+		// status is the backend's response, 0 is a network error and -1 a
+		// request the client canceled
+		status int
+		// concurrent makes another request, while this one is in flight
+		concurrent bool
+		err        error
+		state      string
+		calls      int
+	}
+
+	refused := errors.New("connection refused")
+	canceled := fmt.Errorf("get: %w", context.Canceled)
+
+	for name, steps := range map[string][]step{
+		"closes after a successful trial": {
+			{at: 0, status: 502, state: BreakerClosed, calls: 1},
+			{at: time.Second, status: 0, err: refused, state: BreakerOpen, calls: 2},
+			{at: 2 * time.Second, status: 200, err: ErrBreakerOpen, state: BreakerOpen, calls: 2},
+			{at: 10*time.Second + 999*time.Millisecond, status: 200, err: ErrBreakerOpen, state: BreakerOpen, calls: 2},
+			{at: 12 * time.Second, status: 200, state: BreakerClosed, calls: 3},
+			{at: 13 * time.Second, status: 502, state: BreakerClosed, calls: 4},
+		},
+		"reopens after a failed trial": {
+			{at: 0, status: 500, state: BreakerClosed, calls: 1},
+			{at: 0, status: 503, state: BreakerOpen, calls: 2},
+			{at: 10 * time.Second, status: 500, state: BreakerOpen, calls: 3},
+			// the cooldown starts over from the failed trial
+			{at: 19 * time.Second, status: 200, err: ErrBreakerOpen, state: BreakerOpen, calls: 3},
+			{at: 20 * time.Second, status: 200, state: BreakerClosed, calls: 4},
+		},
+		"lets a single trial through": {
+			{at: 0, status: 500, state: BreakerClosed, calls: 1},
+			{at: 0, status: 500, state: BreakerOpen, calls: 2},
+			{at: 10 * time.Second, status: 200, concurrent: true, state: BreakerClosed, calls: 3},
+		},
+		"a success resets the failures": {
+			{at: 0, status: 500, state: BreakerClosed, calls: 1},
+			{at: 0, status: 200, state: BreakerClosed, calls: 2},
+			{at: 0, status: 500, state: BreakerClosed, calls: 3},
+			{at: 0, status: 404, state: BreakerClosed, calls: 4},
+			{at: 0, status: 0, err: refused, state: BreakerClosed, calls: 5},
+		},
+		"a canceled request is neither a failure nor a success": {
+			{at: 0, status: 500, state: BreakerClosed, calls: 1},
+			{at: 0, status: -1, err: canceled, state: BreakerClosed, calls: 2},
+			{at: 0, status: 500, state: BreakerOpen, calls: 3},
+			// a canceled trial lets the next request be the trial
+			{at: 10 * time.Second, status: -1, err: canceled, state: BreakerHalfOpen, calls: 4},
+			{at: 10 * time.Second, status: 500, state: BreakerOpen, calls: 5},
+			{at: 20 * time.Second, status: -1, err: canceled, state: BreakerHalfOpen, calls: 6},
+			{at: 20 * time.Second, status: 200, state: BreakerClosed, calls: 7},
+		},
+	} {
+		start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+		now := start
+		b := NewBreaker(2, 10*time.Second)
+		b.now = func() time.Time { return now }
+
+		var calls int
+		var current step
+		var fetch func() error
+
+		backend := func(req *http.Request) (*http.Response, error) {
+			calls++
+
+			if current.concurrent {
+				// the trial is in flight, so others fail fast
+				current.concurrent = false
+				assert.Equal(t, BreakerHalfOpen, b.State(), name)
+				assert.Equal(t, ErrBreakerOpen, fetch(), name)
+			}
+
+			switch current.status {
+			case 0:
+				return nil, refused
+			case -1:
+				return nil, canceled
+			}
+
+			return &http.Response{
+				StatusCode: current.status,
+				Body:       ioutil.NopCloser(strings.NewReader("")),
+				Request:    req,
+			}, nil
+		}
+		guarded := b.Fetcher(geo.FetcherFunc(backend))
+
+		fetch = func() error {
+			req, _ := http.NewRequest("GET", "http://localhost/geo", nil)
+			_, err := guarded.Do(req)
+			return err
+		}
+
+		for i, s := range steps {
+			now, current = start.Add(s.at), s
+
+			assert.Equal(t, s.err, fetch(), "%s: step %d", name, i)
+			assert.Equal(t, s.state, b.State(), "%s: step %d", name, i)
+			assert.Equal(t, s.calls, calls, "%s: step %d", name, i)
+		}
+	}
+}
+
+func TestBreakerThreshold(t *testing.T) {
+	var n int
+	b := NewBreaker(0, time.Second)
+	f := b.Fetcher(fakeFetcher(http.StatusInternalServerError, "", &n))
+
+	// a threshold of 0 never opens
+	for i := 0; i < 10; i++ {
+		req, _ := http.NewRequest("GET", "http://localhost/geo", nil)
+		_, err := f.Do(req)
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, BreakerClosed, b.State())
+	assert.Equal(t, 10, n)
+}
+
+func TestBreakerErrorRate(t *testing.T) {
+	now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewBreaker(0, time.Second)
+	b.now = func() time.Time { return now }
+
+	rate, requests := b.ErrorRate()
+	assert.Equal(t, 0.0, rate)
+	assert.Equal(t, 0, requests)
+
+	b.record(now.Add(-10*time.Minute), true)
+	b.record(now.Add(-time.Minute), true)
+	b.record(now, false)
+
+	// only the last 5 minutes count
+	rate, requests = b.ErrorRate()
+	assert.Equal(t, 0.5, rate)
+	assert.Equal(t, 2, requests)
+
+	// and only the last 100 requests
+	for i := 0; i < 150; i++ {
+		b.record(now, i < 100)
+	}
+
+	rate, requests = b.ErrorRate()
+	assert.Equal(t, 0.5, rate)
+	assert.Equal(t, 100, requests)
+}
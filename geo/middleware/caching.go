@@ -1,9 +1,177 @@
 package middleware
 
-// func Memcache(f geo.Fetcher) geo.Fetcher {
-//     client :=
-//
-//     return geo.FetcherFunc(func(req *http.Request) (*http.Response, error) {
-//
-//     }
-// }
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+type (
+	cacheEntry struct {
+		key     string
+		status  int
+		header  http.Header
+		body    []byte
+		expires time.Time
+	}
+	memoryCache struct {
+		sync.Mutex
+		ttl     time.Duration
+		size    int
+		entries map[string]*list.Element
+		order   *list.List // oldest first
+	}
+)
+
+// CacheHeader is set to HIT or MISS on responses passing through the
+// cache, so the middlewares in front of it knows the cache status.
+const CacheHeader = "X-Cache"
+
+// Cache keeps successful GET responses in memory for ttl, and holds at most
+// size responses, evicting the oldest first. A size of 0 is unlimited.
+// Errors the providers report in a 200 response, like google's
+// OVER_QUERY_LIMIT, aren't kept either.
+func Cache(f geo.Fetcher, ttl time.Duration, size int) geo.Fetcher {
+	c := &memoryCache{ttl: ttl, size: size, entries: map[string]*list.Element{}, order: list.New()}
+
+	return geo.FetcherFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method != "GET" {
+			return f.Do(req)
+		}
+
+		key := req.URL.String()
+
+		if e, ok := c.get(key); ok {
+			return e.response(req, "HIT"), nil
+		}
+
+		resp, err := f.Do(req)
+
+		if err != nil || resp.StatusCode != http.StatusOK {
+			if resp != nil {
+				resp.Header.Set(CacheHeader, "MISS")
+			}
+			return resp, err
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !succeeded(body) {
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+			resp.Header.Set(CacheHeader, "MISS")
+			return resp, nil
+		}
+
+		e := cacheEntry{
+			key:     key,
+			status:  resp.StatusCode,
+			header:  resp.Header,
+			body:    body,
+			expires: time.Now().Add(ttl),
+		}
+		c.set(key, e)
+
+		return e.response(req, "MISS"), nil
+	})
+}
+
+// succeeded reports whether the body is a successful result, by the status
+// google, bing and mapquest include in their json. Other bodies, like map
+// images, are successful.
+func succeeded(body []byte) bool {
+	var v struct {
+		Status     *string `json:"status"`
+		StatusCode *int    `json:"statusCode"`
+		Info       *struct {
+			StatusCode int `json:"statuscode"`
+		} `json:"info"`
+	}
+
+	if json.Unmarshal(body, &v) != nil {
+		return true
+	}
+
+	switch {
+	case v.Status != nil:
+		return *v.Status == "OK" || *v.Status == "ZERO_RESULTS"
+	case v.StatusCode != nil:
+		return *v.StatusCode == http.StatusOK
+	case v.Info != nil:
+		return v.Info.StatusCode == 0
+	}
+
+	return true
+}
+
+func (c *memoryCache) get(key string) (cacheEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	el, ok := c.entries[key]
+
+	if !ok {
+		return cacheEntry{}, false
+	}
+
+	e := el.Value.(cacheEntry)
+
+	if time.Now().After(e.expires) {
+		c.remove(el)
+		return e, false
+	}
+
+	return e, true
+}
+
+func (c *memoryCache) set(key string, e cacheEntry) {
+	c.Lock()
+	defer c.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+
+	c.entries[key] = c.order.PushBack(e)
+
+	for c.size > 0 && c.order.Len() > c.size {
+		c.remove(c.order.Front())
+	}
+}
+
+func (c *memoryCache) remove(el *list.Element) {
+	delete(c.entries, el.Value.(cacheEntry).key)
+	c.order.Remove(el)
+}
+
+func (e cacheEntry) response(req *http.Request, status string) *http.Response {
+	header := http.Header{}
+
+	for k, v := range e.header {
+		header[k] = v
+	}
+
+	header.Set(CacheHeader, status)
+
+	return &http.Response{
+		Status:        http.StatusText(e.status),
+		StatusCode:    e.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"container/list"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+// fakeFetcher answers every request with the body, and counts them.
+func fakeFetcher(status int, body string, n *int) geo.Fetcher {
+	return geo.FetcherFunc(func(req *http.Request) (*http.Response, error) {
+		*n++
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	})
+}
+
+func TestCache(t *testing.T) {
+	var n int
+	f := Cache(fakeFetcher(http.StatusOK, "ok", &n), time.Minute, 10)
+
+	for i, expected := range []string{"MISS", "HIT"} {
+		req, _ := http.NewRequest("GET", "http://localhost/geo?address=valby", nil)
+		resp, err := f.Do(req)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, resp.Header.Get(CacheHeader), i)
+
+		b, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, "ok", string(b))
+	}
+
+	assert.Equal(t, 1, n)
+
+	// errors aren't cached
+	f = Cache(fakeFetcher(http.StatusInternalServerError, "", &n), time.Minute, 10)
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "http://localhost/geo", nil)
+		resp, _ := f.Do(req)
+		assert.Equal(t, "MISS", resp.Header.Get(CacheHeader))
+	}
+
+	assert.Equal(t, 3, n)
+}
+
+func TestCacheProviderErrors(t *testing.T) {
+	for body, cached := range map[string]bool{
+		`{"status":"OK","results":[]}`:                              true,
+		`{"status":"ZERO_RESULTS","results":[]}`:                    true,
+		`{"status":"OVER_QUERY_LIMIT","results":[]}`:                false,
+		`{"status":"REQUEST_DENIED","error_message":"invalid key"}`: false,
+		`{"status":"INVALID_REQUEST","results":[]}`:                 false,
+		`{"statusCode":200,"statusDescription":"OK"}`:               true,
+		`{"statusCode":401,"statusDescription":"Unauthorized"}`:     false,
+		`{"info":{"statuscode":0},"results":[]}`:                    true,
+		`{"info":{"statuscode":403,"messages":["bad key"]}}`:        false,
+		"\x89PNG\r\n": true,
+	} {
+		var n int
+		f := Cache(fakeFetcher(http.StatusOK, body, &n), time.Minute, 10)
+
+		for i := 0; i < 2; i++ {
+			req, _ := http.NewRequest("GET", "http://localhost/geo", nil)
+			resp, err := f.Do(req)
+			assert.Nil(t, err)
+
+			// the body is still there, even if it isn't cached
+			b, _ := ioutil.ReadAll(resp.Body)
+			assert.Equal(t, body, string(b))
+		}
+
+		if cached {
+			assert.Equal(t, 1, n, body)
+		} else {
+			assert.Equal(t, 2, n, body)
+		}
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c := &memoryCache{size: 2, entries: map[string]*list.Element{}, order: list.New()}
+	fresh, expired := time.Now().Add(time.Minute), time.Now().Add(-time.Minute)
+
+	c.set("a", cacheEntry{key: "a", expires: expired})
+	c.set("b", cacheEntry{key: "b", expires: fresh})
+
+	_, ok := c.get("a")
+	assert.False(t, ok)
+
+	// the expired a is gone, so the fresh a is newer than b.
+	c.set("a", cacheEntry{key: "a", expires: fresh})
+	c.set("c", cacheEntry{key: "c", expires: fresh})
+
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true} {
+		_, ok := c.get(key)
+		assert.Equal(t, expected, ok, key)
+	}
+
+	assert.Equal(t, 2, c.order.Len())
+}
@@ -1,46 +1,195 @@
 package middleware
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
+	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/harboe/gogeo/geo"
 )
 
-func Logger(f geo.Fetcher) geo.Fetcher {
+type (
+	// Level of a log entry
+	Level int
+	// Fields of a log entry
+	Fields map[string]interface{}
+	// Log writes entries at or above it's level, as json lines or as
+	// plain text lines of key=value pairs.
+	Log struct {
+		sync.Mutex
+		w     io.Writer
+		Level Level
+		JSON  bool
+	}
+	requestIDKey struct{}
+	loggedBody   struct {
+		io.ReadCloser
+		done  func(bytes int)
+		bytes int
+	}
+)
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// RequestIDHeader is read from inbound requests, and set on the response.
+const RequestIDHeader = "X-Request-ID"
+
+var levels = []string{"debug", "info", "warn", "error"}
+
+// DefaultLog is used by the logging middlewares.
+var DefaultLog = NewLog(os.Stderr, LevelInfo, false)
+
+// NewLog returns a log writing to w.
+func NewLog(w io.Writer, level Level, json bool) *Log {
+	return &Log{w: w, Level: level, JSON: json}
+}
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	for i, l := range levels {
+		if strings.EqualFold(l, s) {
+			return Level(i), nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("unknown log level %q, expected one of %s", s, strings.Join(levels, ", "))
+}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "unknown"
+	}
+
+	return levels[l]
+}
+
+// Print writes an entry, if level is at or above the level of the log.
+func (l *Log) Print(level Level, msg string, fields Fields) {
+	if l == nil || level < l.Level {
+		return
+	}
+
+	entry := Fields{}
+	for k, v := range fields {
+		entry[k] = v
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+
+	var line string
+
+	if l.JSON {
+		b, _ := json.Marshal(entry)
+		line = string(b)
+	} else {
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		line = fmt.Sprintf("%s %-5s %s", entry["time"], entry["level"], msg)
+		for _, k := range keys {
+			line += fmt.Sprintf(" %s=%v", k, fields[k])
+		}
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	fmt.Fprintln(l.w, line)
+}
+
+// WithRequestID returns a context carrying the request id, which is
+// logged with the provider requests.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id of ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random request id.
+func NewRequestID() string {
+	return randomID(8)
+}
+
+// Logger logs each request of the provider at debug level, or warn level
+// if it fails, to the DefaultLog. The entry is written when the response
+// body is closed, so the bytes and duration covers the whole response.
+func Logger(f geo.Fetcher, provider string) geo.Fetcher {
 	return geo.FetcherFunc(func(req *http.Request) (*http.Response, error) {
 		start := time.Now()
 		resp, err := f.Do(req)
-		end := time.Now()
-		status, size := 0, float64(0)
-
-		if resp != nil {
-			status = resp.StatusCode
-			size = float64(resp.ContentLength) / 1024
+		fields := Fields{
+			"provider": provider,
+			"url":      geo.RedactURL(req.URL.String()),
+			"result":   ErrorClass(resp, err),
 		}
 
-		entry := fmt.Sprintf("%24s | %3d | %12v | %8.2fKb | %s",
-			strings.Replace(strings.ToUpper(req.URL.Host), "WWW.", "", 1),
-			status,
-			end.Sub(start),
-			size,
-			req.URL,
-		)
+		if id := RequestID(req.Context()); len(id) > 0 {
+			fields["request_id"] = id
+		}
 
 		if err != nil {
-			msg := err.Error()
+			fields["duration_ms"] = durationMS(time.Since(start))
+			fields["error"] = geo.RedactError(err).Error()
+			DefaultLog.Print(LevelWarn, "fetch", fields)
+			return resp, err
+		}
 
-			if i := strings.Index(msg, ": "); i > 0 {
-				msg = msg[i+2:]
-			}
+		fields["status"] = resp.StatusCode
 
-			entry += " " + msg
+		if c := resp.Header.Get(CacheHeader); len(c) > 0 {
+			fields["cache"] = strings.ToLower(c)
 		}
 
-		log.Println(entry)
+		resp.Body = &loggedBody{ReadCloser: resp.Body, done: func(bytes int) {
+			fields["bytes"] = bytes
+			fields["duration_ms"] = durationMS(time.Since(start))
+
+			level := LevelDebug
+			if resp.StatusCode >= 400 {
+				level = LevelWarn
+			}
+
+			DefaultLog.Print(level, "fetch", fields)
+		}}
+
 		return resp, err
 	})
 }
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += n
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	if b.done != nil {
+		b.done(b.bytes)
+		b.done = nil
+	}
+
+	return b.ReadCloser.Close()
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+var (
+	providerRequests = DefaultRegistry.Counter("gogeo_provider_requests_total",
+		"Requests to the providers, by result: ok, client_error, server_error, timeout, canceled, breaker_open or network.",
+		"provider", "result")
+	providerDuration = DefaultRegistry.Histogram("gogeo_provider_request_duration_seconds",
+		"Latency of the requests to the providers.",
+		DefaultBuckets, "provider")
+	providerBytes = DefaultRegistry.Counter("gogeo_provider_response_bytes_total",
+		"Bytes fetched from the providers.",
+		"provider")
+	providerCache = DefaultRegistry.Counter("gogeo_provider_cache_requests_total",
+		"Requests to the providers by cache status, hit or miss.",
+		"provider", "cache")
+	_ = DefaultRegistry.GaugeFunc("gogeo_provider_cache_hit_ratio",
+		"Ratio of requests to the providers served by the cache.",
+		cacheHitRatio, "provider")
+)
+
+type countingBody struct {
+	io.ReadCloser
+	provider string
+}
+
+// Metrics records the requests, latency, errors, cache status and bytes
+// fetched of the provider in the DefaultRegistry. Put it in front of the
+// Cache to count the cache hits.
+func Metrics(f geo.Fetcher, provider string) geo.Fetcher {
+	return geo.FetcherFunc(func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		resp, err := f.Do(req)
+
+		providerDuration.Observe(time.Since(start).Seconds(), provider)
+		providerRequests.Add(1, provider, ErrorClass(resp, err))
+
+		if resp != nil {
+			switch resp.Header.Get(CacheHeader) {
+			case "HIT":
+				providerCache.Add(1, provider, "hit")
+			case "MISS":
+				providerCache.Add(1, provider, "miss")
+			}
+
+			resp.Body = countingBody{resp.Body, provider}
+		}
+
+		return resp, err
+	})
+}
+
+func (b countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	providerBytes.Add(float64(n), b.provider)
+	return n, err
+}
+
+// ErrorClass classifies the result of a request: ok, client_error,
+// server_error, timeout, canceled, breaker_open or network.
+func ErrorClass(resp *http.Response, err error) string {
+	if err == nil {
+		switch {
+		case resp.StatusCode >= 500:
+			return "server_error"
+		case resp.StatusCode >= 400:
+			return "client_error"
+		}
+		return "ok"
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+
+	if errors.Is(err, ErrBreakerOpen) {
+		return "breaker_open"
+	}
+
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return "timeout"
+	}
+
+	return "network"
+}
+
+func cacheHitRatio() (samples []Sample) {
+	for _, p := range geo.Providers() {
+		hit, miss := providerCache.Value(p, "hit"), providerCache.Value(p, "miss")
+
+		if hit+miss > 0 {
+			samples = append(samples, Sample{Labels: []string{p}, Value: hit / (hit + miss)})
+		}
+	}
+
+	return
+}
@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// Registry holds metrics and writes them in the prometheus text
+	// format. It only supports what gogeo needs: counters, histograms and
+	// gauges computed when scraped.
+	Registry struct {
+		sync.Mutex
+		metrics []*Metric
+	}
+	// Metric is a counter or histogram with labels, or a gauge func.
+	Metric struct {
+		r       *Registry
+		name    string
+		help    string
+		typ     string
+		labels  []string
+		buckets []float64
+		series  map[string]*series
+		gauge   func() []Sample
+	}
+	// Sample is a labeled value of a gauge func.
+	Sample struct {
+		Labels []string
+		Value  float64
+	}
+	series struct {
+		labels []string
+		value  float64
+		counts []uint64
+		count  uint64
+	}
+)
+
+// DefaultBuckets are the latency buckets in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultRegistry is served by the http server at /metrics.
+var DefaultRegistry = &Registry{}
+
+func (r *Registry) add(m *Metric) *Metric {
+	r.Lock()
+	defer r.Unlock()
+
+	m.r, m.series = r, map[string]*series{}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// Counter registers a counter.
+func (r *Registry) Counter(name, help string, labels ...string) *Metric {
+	return r.add(&Metric{name: name, help: help, typ: "counter", labels: labels})
+}
+
+// Histogram registers a histogram with the upper bounds of the buckets.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Metric {
+	return r.add(&Metric{name: name, help: help, typ: "histogram", labels: labels, buckets: buckets})
+}
+
+// GaugeFunc registers a gauge, which values are computed by fn when
+// scraped.
+func (r *Registry) GaugeFunc(name, help string, fn func() []Sample, labels ...string) *Metric {
+	return r.add(&Metric{name: name, help: help, typ: "gauge", labels: labels, gauge: fn})
+}
+
+func (m *Metric) get(labels []string) *series {
+	if len(labels) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d labels, got %d", m.name, len(m.labels), len(labels)))
+	}
+
+	key := strings.Join(labels, "\xff")
+	s, ok := m.series[key]
+
+	if !ok {
+		s = &series{labels: append([]string{}, labels...), counts: make([]uint64, len(m.buckets))}
+		m.series[key] = s
+	}
+
+	return s
+}
+
+// Add adds v to the counter with the label values.
+func (m *Metric) Add(v float64, labels ...string) {
+	m.r.Lock()
+	defer m.r.Unlock()
+
+	m.get(labels).value += v
+}
+
+// Observe adds v to the histogram with the label values.
+func (m *Metric) Observe(v float64, labels ...string) {
+	m.r.Lock()
+	defer m.r.Unlock()
+
+	s := m.get(labels)
+	s.value += v
+	s.count++
+
+	for i, b := range m.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+}
+
+// Value returns the counter value, or the histogram sum, of the label
+// values.
+func (m *Metric) Value(labels ...string) float64 {
+	m.r.Lock()
+	defer m.r.Unlock()
+
+	if s, ok := m.series[strings.Join(labels, "\xff")]; ok {
+		return s.value
+	}
+
+	return 0
+}
+
+// WriteTo writes every metric in the prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.Lock()
+	metrics := append([]*Metric{}, r.metrics...)
+	r.Unlock()
+
+	var b strings.Builder
+
+	for _, m := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+
+		if m.gauge != nil {
+			for _, s := range m.gauge() {
+				fmt.Fprintf(&b, "%s%s %s\n", m.name, labelPairs(m.labels, s.Labels, "", ""), formatFloat(s.Value))
+			}
+			continue
+		}
+
+		r.Lock()
+		keys := make([]string, 0, len(m.series))
+		for k := range m.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			s := m.series[k]
+
+			if m.typ == "counter" {
+				fmt.Fprintf(&b, "%s%s %s\n", m.name, labelPairs(m.labels, s.labels, "", ""), formatFloat(s.value))
+				continue
+			}
+
+			for i, le := range m.buckets {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, labelPairs(m.labels, s.labels, "le", formatFloat(le)), s.counts[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, labelPairs(m.labels, s.labels, "le", "+Inf"), s.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", m.name, labelPairs(m.labels, s.labels, "", ""), formatFloat(s.value))
+			fmt.Fprintf(&b, "%s_count%s %d\n", m.name, labelPairs(m.labels, s.labels, "", ""), s.count)
+		}
+		r.Unlock()
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics to prometheus.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+func labelPairs(names, values []string, extra, extraValue string) string {
+	var pairs []string
+
+	for i, n := range names {
+		pairs = append(pairs, n+`="`+escapeLabel(values[i])+`"`)
+	}
+
+	if len(extra) > 0 {
+		pairs = append(pairs, extra+`="`+extraValue+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
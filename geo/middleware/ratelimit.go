@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+// RateLimit allows at most rps requests per second, with bursts of up to
+// burst requests. Requests over the limit waits for their turn.
+func RateLimit(f geo.Fetcher, rps float64, burst int) geo.Fetcher {
+	if burst < 1 {
+		burst = 1
+	}
+
+	var mu sync.Mutex
+	tokens, last := float64(burst), time.Now()
+
+	return geo.FetcherFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		now := time.Now()
+		tokens += now.Sub(last).Seconds() * rps
+		last = now
+
+		if tokens > float64(burst) {
+			tokens = float64(burst)
+		}
+
+		tokens--
+		wait := time.Duration(0)
+
+		if tokens < 0 {
+			wait = time.Duration(-tokens / rps * float64(time.Second))
+		}
+		mu.Unlock()
+
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+
+		return f.Do(req)
+	})
+}
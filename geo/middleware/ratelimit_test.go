@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	var n int
+	f := RateLimit(fakeFetcher(http.StatusOK, "", &n), 20, 2)
+
+	start := time.Now()
+
+	// the burst goes through at once
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "http://localhost/geo", nil)
+		f.Do(req)
+	}
+
+	assert.True(t, time.Since(start) < 25*time.Millisecond, time.Since(start))
+
+	// the next waits for a token, 1/20 of a second
+	req, _ := http.NewRequest("GET", "http://localhost/geo", nil)
+	f.Do(req)
+
+	assert.True(t, time.Since(start) >= 40*time.Millisecond, time.Since(start))
+	assert.Equal(t, 3, n)
+}
+
+func TestRateLimitBurst(t *testing.T) {
+	var n int
+	f := RateLimit(fakeFetcher(http.StatusOK, "", &n), 100, 0)
+
+	// a burst below 1 is 1, so the second request waits 1/100 of a second
+	start := time.Now()
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "http://localhost/geo", nil)
+		f.Do(req)
+	}
+
+	assert.True(t, time.Since(start) >= 8*time.Millisecond, time.Since(start))
+	assert.Equal(t, 2, n)
+}
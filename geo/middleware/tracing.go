@@ -0,0 +1,234 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+type (
+	// Tracer exports finished spans as json lines, eg. to stdout or a
+	// file, in the shape of opentelemetry spans.
+	Tracer struct {
+		sync.Mutex
+		w io.Writer
+	}
+	// Span is a timed operation of a trace. A nil span is a no-op, so
+	// callers doesn't need to check if tracing is enabled.
+	Span struct {
+		TraceID    string            `json:"traceId"`
+		SpanID     string            `json:"spanId"`
+		ParentID   string            `json:"parentSpanId,omitempty"`
+		Name       string            `json:"name"`
+		Kind       string            `json:"kind"`
+		Start      time.Time         `json:"startTime"`
+		End        time.Time         `json:"endTime"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+		Status     string            `json:"status"`
+		Error      string            `json:"error,omitempty"`
+
+		tracer *Tracer
+	}
+	spanKey struct{}
+	// tracedProvider starts a span for each lookup, and sends the
+	// provider requests with the span.
+	tracedProvider struct {
+		ctx      context.Context
+		name     string
+		provider geo.Provider
+	}
+)
+
+// DefaultTracer is used by the tracing middlewares, tracing is disabled
+// while it's nil.
+var DefaultTracer *Tracer
+
+// NewTracer returns a tracer writing to w.
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{w: w}
+}
+
+// Start starts a span, which is a child of the span in ctx if any.
+// A nil tracer returns a nil span.
+func (t *Tracer) Start(ctx context.Context, name, kind string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := &Span{Name: name, Kind: kind, Start: time.Now(), SpanID: randomID(8), Status: "ok", tracer: t}
+
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.TraceID, s.ParentID = parent.TraceID, parent.SpanID
+	} else {
+		s.TraceID = randomID(16)
+	}
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// StartRemote starts a server span, continuing the trace of a w3c
+// traceparent header, or a new trace if the header isn't valid.
+func (t *Tracer) StartRemote(ctx context.Context, name, traceparent string) (context.Context, *Span) {
+	ctx, s := t.Start(ctx, name, "server")
+
+	if traceID, parentID, ok := ParseTraceparent(traceparent); ok && s != nil {
+		s.TraceID, s.ParentID = traceID, parentID
+	}
+
+	return ctx, s
+}
+
+// SpanFromContext returns the current span of ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SetAttribute sets an attribute of the span.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+
+	if s.Attributes == nil {
+		s.Attributes = map[string]string{}
+	}
+
+	s.Attributes[key] = value
+}
+
+// Finish ends the span, records err if any and exports the span.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+
+	s.End = time.Now()
+
+	if err != nil {
+		s.Status, s.Error = "error", geo.RedactError(err).Error()
+	}
+
+	b, _ := json.Marshal(s)
+
+	s.tracer.Lock()
+	defer s.tracer.Unlock()
+	s.tracer.w.Write(append(b, '\n'))
+}
+
+// Traceparent returns the w3c traceparent header of the span.
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+
+	return "00-" + s.TraceID + "-" + s.SpanID + "-01"
+}
+
+// ParseTraceparent returns the trace and parent span id of a w3c
+// traceparent header: version-traceid-parentid-flags
+func ParseTraceparent(h string) (traceID, parentID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", false
+	}
+
+	for _, p := range parts[:4] {
+		if _, err := hex.DecodeString(p); err != nil || p != strings.ToLower(p) {
+			return "", "", false
+		}
+	}
+
+	if parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return "", "", false
+	}
+
+	return parts[1], parts[2], true
+}
+
+// Tracing starts a client span for each request of the provider, and
+// propagates the trace with the traceparent header. Put it in front of
+// the Cache to record the cache status.
+func Tracing(f geo.Fetcher, provider string) geo.Fetcher {
+	return geo.FetcherFunc(func(req *http.Request) (*http.Response, error) {
+		ctx, span := DefaultTracer.Start(req.Context(), "fetch "+provider, "client")
+
+		if span == nil {
+			return f.Do(req)
+		}
+
+		req = req.Clone(ctx)
+		req.Header.Set("traceparent", span.Traceparent())
+		span.SetAttribute("provider", provider)
+		span.SetAttribute("http.method", req.Method)
+		span.SetAttribute("http.url", geo.RedactURL(req.URL.String()))
+
+		resp, err := f.Do(req)
+
+		if resp != nil {
+			span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+
+			if c := resp.Header.Get(CacheHeader); len(c) > 0 {
+				span.SetAttribute("cache", strings.ToLower(c))
+			}
+		}
+
+		span.Finish(err)
+		return resp, err
+	})
+}
+
+// TraceProvider returns a provider, which starts a span of the lookups
+// and images in ctx, and sends the requests with the span.
+func TraceProvider(ctx context.Context, name string, p geo.Provider) geo.Provider {
+	if DefaultTracer == nil {
+		return geo.WithContext(ctx, p)
+	}
+
+	return tracedProvider{ctx: ctx, name: name, provider: p}
+}
+
+func (t tracedProvider) start(typ, qry string) (geo.Provider, *Span) {
+	ctx, span := DefaultTracer.Start(t.ctx, t.name+" "+typ, "internal")
+	span.SetAttribute("provider", t.name)
+	span.SetAttribute("query.type", typ)
+	span.SetAttribute("query", qry)
+	return geo.WithContext(ctx, t.provider), span
+}
+
+func (t tracedProvider) Address(address string) (geo.Result, error) {
+	p, span := t.start("geocode", address)
+	r, err := p.Address(address)
+	span.Finish(err)
+	return r, err
+}
+
+func (t tracedProvider) Location(loc geo.Location) (geo.Result, error) {
+	p, span := t.start("reverse", loc.String())
+	r, err := p.Location(loc)
+	span.Finish(err)
+	return r, err
+}
+
+func (t tracedProvider) Image(markers []string, opts geo.MapOptions) ([]byte, error) {
+	p, span := t.start("image", strings.Join(markers, "|"))
+	b, err := p.Image(markers, opts)
+	span.Finish(err)
+	return b, err
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -11,13 +11,22 @@ var (
 	DefaultSize = Size{Width: 250, Height: 250}
 	// DefaultMapOptions if nothing is specificed in the request
 	DefaultMapOptions = MapOptions{Size: DefaultSize, Scale: 0, Zoom: 0}
+	// MaxSize of a map, before it's scaled, the same as google allows
+	MaxSize = Size{Width: 640, Height: 640}
+)
+
+const (
+	// MaxScale of a map, 0 is the same as 1
+	MaxScale = 2
+	// MaxZoom of a map, 0 fits the markers
+	MaxZoom = 22
 )
 
 type (
 	// Location represents geo latitude,longitude coordinates
 	Location struct {
-		Latitude  float64 `json:"lat" xml:"location>lat"`
-		Longitude float64 `json:"lng" xml:"location>lng"`
+		Latitude  float64 `json:"lat" xml:"lat,attr"`
+		Longitude float64 `json:"lng" xml:"lng,attr"`
 	}
 	// Result represents the response for any given provider
 	Result struct {
@@ -29,6 +38,12 @@ type (
 		Zip      string `json:"zip,omitempty" xml:"zip,omitempty"`
 		State    string `json:"state,omitempty" xml:"state,omitempty"`
 		Location `json:"location"`
+		// Bounds is the recommended viewport of the result, if known.
+		Bounds *Bounds `json:"bounds,omitempty" xml:"bounds,omitempty" yaml:"bounds,omitempty"`
+		// Provider is the name of the provider which made the lookup.
+		Provider string `json:"provider,omitempty" xml:"provider,attr,omitempty" yaml:"provider,omitempty"`
+		// Error is set when the lookup failed.
+		Error string `json:"error,omitempty" xml:"error,omitempty" yaml:"error,omitempty"`
 	}
 	// Size of a image
 	Size struct {
@@ -40,6 +55,12 @@ type (
 		Size
 		Scale uint64
 		Zoom  uint64
+		// Center of the map, if nil the provider decides.
+		Center *Location
+		// Path is a list of points to draw a line between.
+		Path []string
+		// Format of the image: png, jpg, gif or webp. Defaults to png.
+		Format string
 	}
 	// Provider for geo and reveresed address lookup
 	Provider interface {
@@ -66,6 +87,24 @@ func (l Location) Valid() error {
 	return nil
 }
 
+// Valid returns a error if the size, scale or zoom is out of range, which
+// keeps the rendered image within MaxSize times MaxScale.
+func (o MapOptions) Valid() error {
+	if o.Width > MaxSize.Width || o.Height > MaxSize.Height {
+		return fmt.Errorf("size %v out of range, max %v", o.Size, MaxSize)
+	}
+
+	if o.Scale > MaxScale {
+		return fmt.Errorf("scale %d out of range, expected 1-%d", o.Scale, MaxScale)
+	}
+
+	if o.Zoom > MaxZoom {
+		return fmt.Errorf("zoom %d out of range, expected 0-%d", o.Zoom, MaxZoom)
+	}
+
+	return nil
+}
+
 func (l Location) String() string {
 	return fmt.Sprintf("%v,%v", l.Latitude, l.Longitude)
 }
@@ -78,3 +78,23 @@ func TestParseSize(t *testing.T) {
 	assert.Equal(t, "123x456", s.String())
 	assert.Nil(t, err)
 }
+
+func TestMapOptionsValid(t *testing.T) {
+	for _, test := range []struct {
+		opts MapOptions
+		err  string
+	}{
+		{DefaultMapOptions, ""},
+		{MapOptions{Size: MaxSize, Scale: 2, Zoom: 22}, ""},
+		{MapOptions{Size: Size{Width: 641, Height: 10}}, "size 641x10 out of range, max 640x640"},
+		{MapOptions{Size: Size{Width: 10, Height: 641}}, "size 10x641 out of range, max 640x640"},
+		{MapOptions{Size: DefaultSize, Scale: 3}, "scale 3 out of range, expected 1-2"},
+		{MapOptions{Size: DefaultSize, Zoom: 23}, "zoom 23 out of range, expected 0-22"},
+	} {
+		if err := test.opts.Valid(); len(test.err) == 0 {
+			assert.Nil(t, err, test.opts.Size.String())
+		} else {
+			assert.EqualError(t, err, test.err)
+		}
+	}
+}
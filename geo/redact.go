@@ -0,0 +1,101 @@
+package geo
+
+import (
+	"net/url"
+	"strings"
+)
+
+// sensitive lists the query parameters of each provider, which holds
+// secrets and are redacted in logs and errors.
+var sensitive = map[string][]string{
+	"bing":     {"key"},
+	"google":   {"key", "signature"},
+	"local":    {},
+	"mapquest": {"key"},
+}
+
+// Sensitive returns the names of the query parameters, which holds secrets
+// for the provider.
+func Sensitive(provider string) []string {
+	return sensitive[strings.ToLower(provider)]
+}
+
+func isSensitive(name string) bool {
+	for _, p := range Providers() {
+		for _, s := range Sensitive(p) {
+			if strings.EqualFold(s, name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Redact masks a secret, only the last 4 characters are kept. Secrets of
+// 8 characters or less are masked completely.
+func Redact(secret string) string {
+	switch {
+	case len(secret) == 0:
+		return ""
+	case len(secret) <= 8:
+		return "****"
+	}
+
+	return "****" + secret[len(secret)-4:]
+}
+
+// RedactURL masks the sensitive query parameters and the password of
+// rawurl. The query keeps it's order, so it still reads like the original.
+// A url which can't be parsed, has everything after the ? redacted as a
+// query, and is otherwise returned as is.
+func RedactURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+
+	if err != nil {
+		if i := strings.Index(rawurl, "?"); i >= 0 {
+			return rawurl[:i+1] + redactQuery(rawurl[i+1:])
+		}
+		return rawurl
+	}
+
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		}
+	}
+
+	u.RawQuery = redactQuery(u.RawQuery)
+	return u.String()
+}
+
+func redactQuery(query string) string {
+	params := strings.Split(query, "&")
+
+	for i, p := range params {
+		k, v := p, ""
+
+		if j := strings.Index(p, "="); j >= 0 {
+			k, v = p[:j], p[j+1:]
+		}
+
+		if name, err := url.QueryUnescape(k); err == nil && isSensitive(name) {
+			if val, err := url.QueryUnescape(v); err == nil {
+				v = val
+			}
+			params[i] = k + "=" + Redact(v)
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+// RedactError masks the secrets of the request url in err, which the http
+// client includes in it's errors.
+func RedactError(err error) error {
+	if e, ok := err.(*url.Error); ok {
+		return &url.Error{Op: e.Op, URL: RedactURL(e.URL), Err: e.Err}
+	}
+
+	return err
+}
@@ -0,0 +1,151 @@
+package geo
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// staticMapServer answers geocoding requests with the location of every
+// address, and static map requests with a png, and records the requests.
+func staticMapServer(t *testing.T, geocode string) (*httptest.Server, *[]*url.URL) {
+	var requests []*url.URL
+	var buf bytes.Buffer
+	assert.Nil(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 10, 10))))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.URL)
+
+		if strings.HasPrefix(req.URL.Path, "/geo") {
+			w.Write([]byte(geocode))
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(buf.Bytes())
+	}))
+
+	return srv, &requests
+}
+
+func TestGoogleImage(t *testing.T) {
+	srv, requests := staticMapServer(t, `{"status":"OK","results":[{"geometry":{"location":{"lat":55.67,"lng":12.5}}}]}`)
+	defer srv.Close()
+
+	p, _ := New("google", Config{APIKey: "secret", GeoURL: srv.URL + "/geo", ImgURL: srv.URL + "/map"})
+
+	b, err := p.Image([]string{"valby", "56.1,10.2"}, MapOptions{Size: Size{Width: 400, Height: 300}, Format: "webp"})
+	assert.Nil(t, err)
+	assert.Equal(t, "RIFF", string(b[:4]))
+
+	// the address is geocoded once to fit the map, and the map gets the
+	// location instead of the address.
+	assert.Len(t, *requests, 2)
+	qry := (*requests)[1].Query()
+	assert.Equal(t, "55.67,12.5|56.1,10.2", qry.Get("markers"))
+	assert.Equal(t, "400x300", qry.Get("size"))
+	assert.Equal(t, "secret", qry.Get("key"))
+	assert.Equal(t, "", qry.Get("format"))
+	assert.NotEmpty(t, qry.Get("zoom"))
+	assert.NotEmpty(t, qry.Get("center"))
+}
+
+func TestBingImage(t *testing.T) {
+	srv, requests := staticMapServer(t, `{"statusDescription":"OK","resourceSets":[{"resources":[{"point":{"coordinates":[55.67,12.5]}}]}]}`)
+	defer srv.Close()
+
+	p, _ := New("bing", Config{APIKey: "secret", GeoURL: srv.URL + "/geo", ImgURL: srv.URL + "/map"})
+
+	_, err := p.Image([]string{"valby", "56.1,10.2"}, MapOptions{
+		Size:   Size{Width: 400, Height: 300},
+		Path:   []string{"55.6,12.5", "56.1,10.2"},
+		Scale:  2,
+		Format: "jpg",
+	})
+	assert.Nil(t, err)
+
+	assert.Len(t, *requests, 2)
+
+	// the center and zoom are in the path
+	u := (*requests)[1]
+	assert.True(t, strings.HasPrefix(u.Path, "/map/"), u.Path)
+	assert.Len(t, strings.Split(u.Path, "/"), 4)
+
+	qry := u.Query()
+	assert.Equal(t, []string{"55.67,12.5", "56.1,10.2"}, qry["pp"])
+	assert.Equal(t, "l,FF2530D9,3;55.6,12.5_56.1,10.2", qry.Get("dc"))
+	assert.Equal(t, "400,300", qry.Get("mapSize"))
+	assert.Equal(t, "Large", qry.Get("dpi"))
+	assert.Equal(t, "jpeg", qry.Get("format"))
+	assert.Equal(t, "secret", qry.Get("key"))
+
+	// a zoom level is sent with the center of the markers, which bing
+	// requires, and the address is still looked up once.
+	*requests = nil
+	_, err = p.Image([]string{"valby"}, MapOptions{Size: DefaultSize, Zoom: 12})
+	assert.Nil(t, err)
+	assert.Len(t, *requests, 2)
+	assert.Equal(t, "/map/55.67,12.5/12", (*requests)[1].Path)
+	assert.Equal(t, []string{"55.67,12.5"}, (*requests)[1].Query()["pp"])
+}
+
+func TestMapQuestImage(t *testing.T) {
+	srv, requests := staticMapServer(t, `{"results":[{"locations":[{"latLng":{"lat":55.67,"lng":12.5}}]}]}`)
+	defer srv.Close()
+
+	p, _ := New("mapquest", Config{APIKey: "secret", GeoURL: srv.URL + "/geo/", ImgURL: srv.URL + "/map"})
+
+	_, err := p.Image([]string{"valby", "56.1,10.2"}, MapOptions{
+		Size:  Size{Width: 400, Height: 300},
+		Path:  []string{"55.6,12.5", "56.1,10.2"},
+		Scale: 2,
+	})
+	assert.Nil(t, err)
+
+	assert.Len(t, *requests, 2)
+	qry := (*requests)[1].Query()
+	assert.Equal(t, "55.67,12.5||56.1,10.2", qry.Get("locations"))
+	assert.Equal(t, "55.6,12.5|56.1,10.2", qry.Get("shape"))
+	assert.Equal(t, "400,300@2x", qry.Get("size"))
+	assert.Equal(t, "png", qry.Get("format"))
+	assert.Equal(t, "secret", qry.Get("key"))
+	assert.NotEmpty(t, qry.Get("zoom"))
+	assert.NotEmpty(t, qry.Get("center"))
+
+	// with a zoom, mapquest centers the map and looks up the address itself
+	*requests = nil
+	_, err = p.Image([]string{"valby"}, MapOptions{Size: DefaultSize, Zoom: 12, Format: "gif"})
+	assert.Nil(t, err)
+	assert.Len(t, *requests, 1)
+	assert.Equal(t, "valby", (*requests)[0].Query().Get("locations"))
+	assert.Equal(t, "gif", (*requests)[0].Query().Get("format"))
+}
+
+func TestGeocodingKey(t *testing.T) {
+	for name, body := range map[string]string{
+		"bing":     `{"statusDescription":"OK","resourceSets":[{"resources":[{"point":{"coordinates":[55.67,12.5]}}]}]}`,
+		"mapquest": `{"results":[{"locations":[{"latLng":{"lat":55.67,"lng":12.5}}]}]}`,
+	} {
+		srv, requests := staticMapServer(t, body)
+		p, _ := New(name, Config{APIKey: "secret", GeoURL: srv.URL + "/geo/"})
+
+		_, err := p.Address("valby")
+		assert.Nil(t, err, name)
+		_, err = p.Location(Location{Latitude: 55.67, Longitude: 12.5})
+		assert.Nil(t, err, name)
+
+		assert.Len(t, *requests, 2, name)
+		for _, u := range *requests {
+			assert.Equal(t, "secret", u.Query().Get("key"), name)
+		}
+
+		srv.Close()
+	}
+}
@@ -0,0 +1,447 @@
+package geo
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// A minimal lossless webp (VP8L) encoder. Neither the standard library nor
+// golang.org/x/image encodes webp, and the encoders that do need cgo, so
+// local static maps are encoded here.
+//
+// Only a small part of the format is written, which suits static maps
+// well with their large areas of flat color:
+//
+//   - the simple file format, a RIFF header and a single VP8L chunk
+//   - the subtract green transform, no predictor, color or palette transform
+//   - no color cache and no meta prefix codes, a single group of five
+//     normal prefix codes, limited to 15 bits
+//   - code lengths written with the code length code, symbols 0-15 and the
+//     zero runs 17 and 18, never 16 or a max symbol
+//   - backward references only to the pixel above or the one to the left,
+//     plane codes 1 and 2, for runs of 3 to 4096 pixels
+//
+// Decoders must handle all of the format, so anything written here is
+// readable by any of them.
+
+const (
+	vp8lMaxLength = 4096
+	vp8lMinLength = 3
+)
+
+// vp8lCodeLengthOrder is the order code length code lengths are written in.
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+type (
+	// vp8lSymbol is either a literal pixel or a backward reference.
+	vp8lSymbol struct {
+		argb     uint32
+		length   int
+		distance int // plane code, 1 is the pixel above, 2 the one left
+	}
+	bitWriter struct {
+		w     io.Writer
+		bits  uint64
+		nbits uint
+		err   error
+	}
+)
+
+func encodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	if width < 1 || height < 1 || width > 1<<14 || height > 1<<14 {
+		return fmt.Errorf("webp: invalid image size %dx%d", width, height)
+	}
+
+	pixels := make([]uint32, 0, width*height)
+	alpha := false
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			alpha = alpha || c.A != 0xff
+			// subtract green transform
+			r, bl := c.R-c.G, c.B-c.G
+			pixels = append(pixels, uint32(c.A)<<24|uint32(r)<<16|uint32(c.G)<<8|uint32(bl))
+		}
+	}
+
+	symbols := vp8lSymbols(pixels, width)
+
+	// histograms for green+length, red, blue, alpha and distance
+	hist := [5][]int{
+		make([]int, 256+24), make([]int, 256), make([]int, 256), make([]int, 256), make([]int, 40),
+	}
+
+	for _, s := range symbols {
+		if s.length == 0 {
+			hist[0][s.argb>>8&0xff]++
+			hist[1][s.argb>>16&0xff]++
+			hist[2][s.argb&0xff]++
+			hist[3][s.argb>>24]++
+		} else {
+			code, _, _ := vp8lPrefix(s.length)
+			hist[0][256+code]++
+			code, _, _ = vp8lPrefix(s.distance)
+			hist[4][code]++
+		}
+	}
+
+	var data bytes.Buffer
+	bw := &bitWriter{w: &data}
+
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	bw.write(boolBit(alpha), 1)
+	bw.write(0, 3) // version
+
+	bw.write(1, 1) // transform present
+	bw.write(2, 2) // subtract green
+	bw.write(0, 1) // no more transforms
+	bw.write(0, 1) // no color cache
+	bw.write(0, 1) // no meta prefix codes
+
+	var lengths [5][]uint8
+	var codes [5][]uint16
+
+	for i := range hist {
+		lengths[i] = huffmanLengths(hist[i], 15)
+		codes[i] = canonicalCodes(lengths[i])
+		bw.writeCodeLengths(lengths[i])
+	}
+
+	for _, s := range symbols {
+		if s.length == 0 {
+			bw.writeCode(codes[0], lengths[0], int(s.argb>>8&0xff))
+			bw.writeCode(codes[1], lengths[1], int(s.argb>>16&0xff))
+			bw.writeCode(codes[2], lengths[2], int(s.argb&0xff))
+			bw.writeCode(codes[3], lengths[3], int(s.argb>>24))
+			continue
+		}
+
+		code, n, extra := vp8lPrefix(s.length)
+		bw.writeCode(codes[0], lengths[0], 256+code)
+		bw.write(extra, n)
+		code, n, extra = vp8lPrefix(s.distance)
+		bw.writeCode(codes[4], lengths[4], code)
+		bw.write(extra, n)
+	}
+
+	bw.flush()
+
+	if bw.err != nil {
+		return bw.err
+	}
+
+	return writeRIFF(w, "VP8L", data.Bytes())
+}
+
+// vp8lSymbols replaces runs of repeated pixels, or pixels equal to the row
+// above, with backward references.
+func vp8lSymbols(pixels []uint32, width int) []vp8lSymbol {
+	var symbols []vp8lSymbol
+
+	for i := 0; i < len(pixels); {
+		left, above := 0, 0
+
+		if i > 0 {
+			for left < vp8lMaxLength && i+left < len(pixels) && pixels[i+left] == pixels[i+left-1] {
+				left++
+			}
+		}
+
+		if i >= width {
+			for above < vp8lMaxLength && i+above < len(pixels) && pixels[i+above] == pixels[i+above-width] {
+				above++
+			}
+		}
+
+		switch {
+		case above >= vp8lMinLength && above >= left:
+			symbols = append(symbols, vp8lSymbol{length: above, distance: 1})
+			i += above
+		case left >= vp8lMinLength:
+			symbols = append(symbols, vp8lSymbol{length: left, distance: 2})
+			i += left
+		default:
+			symbols = append(symbols, vp8lSymbol{argb: pixels[i]})
+			i++
+		}
+	}
+
+	return symbols
+}
+
+// vp8lPrefix splits a length or distance into a prefix code and extra bits.
+func vp8lPrefix(v int) (code int, nbits uint, extra uint32) {
+	d := v - 1
+
+	if d < 4 {
+		return d, 0, 0
+	}
+
+	h := uint(0)
+	for d>>(h+1) != 0 {
+		h++
+	}
+
+	second := (d >> (h - 1)) & 1
+	nbits = h - 1
+	return int(2*h) + second, nbits, uint32(d) & (1<<nbits - 1)
+}
+
+func writeRIFF(w io.Writer, fourcc string, data []byte) error {
+	pad := len(data) & 1
+	hdr := make([]byte, 20)
+	copy(hdr[0:], "RIFF")
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(4+8+len(data)+pad))
+	copy(hdr[8:], "WEBP")
+	copy(hdr[12:], fourcc)
+	binary.LittleEndian.PutUint32(hdr[16:], uint32(len(data)))
+
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+
+	if pad == 1 {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+
+	return nil
+}
+
+func boolBit(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// write writes the n lowest bits of v, least significant bit first.
+func (w *bitWriter) write(v uint32, n uint) {
+	w.bits |= uint64(v) << w.nbits
+	w.nbits += n
+
+	for w.nbits >= 8 && w.err == nil {
+		_, w.err = w.w.Write([]byte{byte(w.bits)})
+		w.bits >>= 8
+		w.nbits -= 8
+	}
+}
+
+func (w *bitWriter) flush() {
+	if w.nbits > 0 {
+		w.write(0, 8-w.nbits)
+	}
+}
+
+// writeCode writes a huffman code, which is read most significant bit first.
+func (w *bitWriter) writeCode(codes []uint16, lengths []uint8, symbol int) {
+	n := uint(lengths[symbol])
+	c := codes[symbol]
+	rev := uint32(0)
+
+	for i := uint(0); i < n; i++ {
+		rev = rev<<1 | uint32(c>>i&1)
+	}
+
+	w.write(rev, n)
+}
+
+// writeCodeLengths writes a normal prefix code, the code lengths are
+// themselves written with a code length code.
+func (w *bitWriter) writeCodeLengths(lengths []uint8) {
+	var tokens [][2]int // code length symbol and repeat count
+	for i := 0; i < len(lengths); {
+		if lengths[i] != 0 {
+			tokens = append(tokens, [2]int{int(lengths[i]), 0})
+			i++
+			continue
+		}
+
+		run := 0
+		for i+run < len(lengths) && lengths[i+run] == 0 && run < 138 {
+			run++
+		}
+
+		switch {
+		case run >= 11:
+			tokens = append(tokens, [2]int{18, run})
+		case run >= 3:
+			tokens = append(tokens, [2]int{17, run})
+		default:
+			for j := 0; j < run; j++ {
+				tokens = append(tokens, [2]int{0, 0})
+			}
+		}
+
+		i += run
+	}
+
+	hist := make([]int, 19)
+	for _, t := range tokens {
+		hist[t[0]]++
+	}
+
+	clLengths := huffmanLengths(hist, 7)
+	clCodes := canonicalCodes(clLengths)
+
+	n := len(vp8lCodeLengthOrder)
+	for n > 4 && clLengths[vp8lCodeLengthOrder[n-1]] == 0 {
+		n--
+	}
+
+	w.write(0, 1) // normal code
+	w.write(uint32(n-4), 4)
+
+	for _, s := range vp8lCodeLengthOrder[:n] {
+		w.write(uint32(clLengths[s]), 3)
+	}
+
+	w.write(0, 1) // max symbol is the alphabet size
+
+	for _, t := range tokens {
+		w.writeCode(clCodes, clLengths, t[0])
+
+		switch t[0] {
+		case 17:
+			w.write(uint32(t[1]-3), 3)
+		case 18:
+			w.write(uint32(t[1]-11), 7)
+		}
+	}
+}
+
+// huffmanLengths returns the code lengths of a huffman code for the
+// histogram, no longer than limit. At least two symbols are always given a
+// length, so the code is a complete tree.
+func huffmanLengths(hist []int, limit uint8) []uint8 {
+	counts := append([]int{}, hist...)
+	used := 0
+
+	for _, c := range counts {
+		if c > 0 {
+			used++
+		}
+	}
+
+	for i := 0; used < 2 && i < len(counts); i++ {
+		if counts[i] == 0 {
+			counts[i] = 1
+			used++
+		}
+	}
+
+	for {
+		lengths := huffmanTree(counts)
+		max := uint8(0)
+
+		for _, l := range lengths {
+			if l > max {
+				max = l
+			}
+		}
+
+		if max <= limit {
+			return lengths
+		}
+
+		// flatten the distribution until the tree is shallow enough
+		for i, c := range counts {
+			if c > 0 {
+				counts[i] = c/2 + 1
+			}
+		}
+	}
+}
+
+type huffmanNode struct {
+	count   int
+	symbols []int
+}
+
+type huffmanHeap []huffmanNode
+
+func (h huffmanHeap) Len() int            { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool  { return h[i].count < h[j].count }
+func (h huffmanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x interface{}) { *h = append(*h, x.(huffmanNode)) }
+func (h *huffmanHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+func huffmanTree(counts []int) []uint8 {
+	lengths := make([]uint8, len(counts))
+	h := &huffmanHeap{}
+
+	for s, c := range counts {
+		if c > 0 {
+			*h = append(*h, huffmanNode{count: c, symbols: []int{s}})
+		}
+	}
+
+	heap.Init(h)
+
+	for h.Len() > 1 {
+		a, b := heap.Pop(h).(huffmanNode), heap.Pop(h).(huffmanNode)
+
+		for _, s := range a.symbols {
+			lengths[s]++
+		}
+		for _, s := range b.symbols {
+			lengths[s]++
+		}
+
+		heap.Push(h, huffmanNode{count: a.count + b.count, symbols: append(a.symbols, b.symbols...)})
+	}
+
+	return lengths
+}
+
+// canonicalCodes assigns canonical huffman codes to the code lengths.
+func canonicalCodes(lengths []uint8) []uint16 {
+	codes := make([]uint16, len(lengths))
+	symbols := make([]int, 0, len(lengths))
+
+	for s, l := range lengths {
+		if l > 0 {
+			symbols = append(symbols, s)
+		}
+	}
+
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return lengths[symbols[i]] < lengths[symbols[j]]
+	})
+
+	code, prev := uint16(0), uint8(0)
+
+	for i, s := range symbols {
+		if i > 0 {
+			code = (code + 1) << (lengths[s] - prev)
+		} else {
+			code <<= lengths[s]
+		}
+
+		codes[s] = code
+		prev = lengths[s]
+	}
+
+	return codes
+}
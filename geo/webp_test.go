@@ -0,0 +1,336 @@
+package geo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// webpTestImage has the parts of a static map: a flat background, which
+// is longer than a backward reference, stripes, noise and a transparent
+// gradient.
+func webpTestImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 60))
+
+	for y := 0; y < 60; y++ {
+		for x := 0; x < 100; x++ {
+			c := color.NRGBA{0xe5, 0xe3, 0xdf, 0xff}
+
+			switch {
+			case x >= 10 && x < 40 && y >= 5 && y < 25:
+				c = color.NRGBA{uint8(x / 7 * 40), 0x80, uint8(y % 3 * 100), 0xff}
+			case x >= 50 && x < 70 && y >= 10 && y < 30:
+				c = color.NRGBA{uint8(x*31 ^ y*17), uint8(x * y), uint8(x*7 + y*13), 0xff}
+			case y >= 50:
+				c = color.NRGBA{0xd9, 0x30, 0x25, uint8(x * 255 / 99)}
+			}
+
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	return img
+}
+
+// testdata/map.webp is webpTestImage, it was checked against the reference
+// decoder, golang.org/x/image/webp, when it was made. Changes to the
+// encoder's output must be checked the same way, before it's replaced.
+func TestWebPGolden(t *testing.T) {
+	golden, err := ioutil.ReadFile("testdata/map.webp")
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, encodeWebP(&buf, webpTestImage()))
+	assert.Equal(t, golden, buf.Bytes())
+
+	// the test decoder agrees with the reference decoder
+	img, err := decodeVP8L(golden)
+	assert.Nil(t, err)
+	assert.Equal(t, webpTestImage(), img)
+}
+
+func TestWebPRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	images := map[string]func(x, y int) color.NRGBA{
+		"flat": func(x, y int) color.NRGBA {
+			return color.NRGBA{0xe5, 0xe3, 0xdf, 0xff}
+		},
+		"stripes": func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x / 7 * 40), 0x80, uint8(y % 3 * 100), 0xff}
+		},
+		"noise": func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 0xff}
+		},
+		"alpha": func(x, y int) color.NRGBA {
+			return color.NRGBA{0xff, 0, 0, uint8(x * 255 / 100)}
+		},
+	}
+
+	for name, pixel := range images {
+		for _, r := range []image.Rectangle{
+			image.Rect(0, 0, 1, 1),
+			image.Rect(0, 0, 37, 23),
+			// runs longer than the maximum backward reference
+			image.Rect(0, 0, 100, 100),
+			image.Rect(10, 10, 60, 40),
+		} {
+			img := image.NewNRGBA(r)
+
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					img.SetNRGBA(x, y, pixel(x-r.Min.X, y-r.Min.Y))
+				}
+			}
+
+			var buf bytes.Buffer
+			assert.Nil(t, encodeWebP(&buf, img), name)
+
+			decoded, err := decodeVP8L(buf.Bytes())
+
+			if !assert.Nil(t, err, "%s %v", name, r) {
+				continue
+			}
+
+			assert.Equal(t, r.Dx(), decoded.Bounds().Dx(), name)
+			assert.Equal(t, r.Dy(), decoded.Bounds().Dy(), name)
+
+			diff := 0
+			for y := 0; y < r.Dy(); y++ {
+				for x := 0; x < r.Dx(); x++ {
+					if img.NRGBAAt(r.Min.X+x, r.Min.Y+y) != decoded.NRGBAAt(x, y) {
+						diff++
+					}
+				}
+			}
+
+			assert.Equal(t, 0, diff, "%s %v: pixels differ", name, r)
+		}
+	}
+
+	assert.NotNil(t, encodeWebP(&bytes.Buffer{}, image.NewNRGBA(image.Rect(0, 0, 0, 10))))
+}
+
+// decodeVP8L decodes the subset of lossless webp, which encodeWebP writes.
+type bitReader struct {
+	data []byte
+	pos  uint
+}
+
+func (r *bitReader) read(n uint) (uint32, error) {
+	v := uint32(0)
+
+	for i := uint(0); i < n; i++ {
+		if r.pos/8 >= uint(len(r.data)) {
+			return 0, errors.New("webp: unexpected end of data")
+		}
+
+		v |= uint32(r.data[r.pos/8]>>(r.pos%8)&1) << i
+		r.pos++
+	}
+
+	return v, nil
+}
+
+// testCode maps the canonical code of each length to its symbol.
+type testCode map[[2]uint32]int
+
+func newTestCode(lengths []uint8) testCode {
+	code := testCode{}
+	codes := canonicalCodes(lengths)
+
+	for s, l := range lengths {
+		if l > 0 {
+			code[[2]uint32{uint32(l), uint32(codes[s])}] = s
+		}
+	}
+
+	return code
+}
+
+func (c testCode) symbol(r *bitReader) (int, error) {
+	code := uint32(0)
+
+	for l := uint32(1); l <= 15; l++ {
+		b, err := r.read(1)
+
+		if err != nil {
+			return 0, err
+		}
+
+		code = code<<1 | b
+
+		if s, ok := c[[2]uint32{l, code}]; ok {
+			return s, nil
+		}
+	}
+
+	return 0, errors.New("webp: invalid code")
+}
+
+func (r *bitReader) codeLengths(alphabet int) ([]uint8, error) {
+	if simple, err := r.read(1); err != nil || simple == 1 {
+		return nil, errors.New("webp: simple codes aren't written")
+	}
+
+	n, err := r.read(4)
+
+	if err != nil {
+		return nil, err
+	}
+
+	clLengths := make([]uint8, 19)
+
+	for _, s := range vp8lCodeLengthOrder[:n+4] {
+		l, err := r.read(3)
+
+		if err != nil {
+			return nil, err
+		}
+
+		clLengths[s] = uint8(l)
+	}
+
+	if max, err := r.read(1); err != nil || max == 1 {
+		return nil, errors.New("webp: max symbol isn't written")
+	}
+
+	cl := newTestCode(clLengths)
+	lengths := make([]uint8, alphabet)
+
+	for i := 0; i < alphabet; {
+		s, err := cl.symbol(r)
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch s {
+		case 16:
+			return nil, errors.New("webp: repeated code lengths aren't written")
+		case 17, 18:
+			bits, base := uint(3), 3
+			if s == 18 {
+				bits, base = 7, 11
+			}
+			run, err := r.read(bits)
+			if err != nil {
+				return nil, err
+			}
+			i += base + int(run)
+		default:
+			lengths[i] = uint8(s)
+			i++
+		}
+	}
+
+	return lengths, nil
+}
+
+func (r *bitReader) prefixValue(code int) (int, error) {
+	if code < 4 {
+		return code + 1, nil
+	}
+
+	extra := uint(code-2) >> 1
+	v, err := r.read(extra)
+	return (2+code&1)<<extra + int(v) + 1, err
+}
+
+func decodeVP8L(b []byte) (*image.NRGBA, error) {
+	if len(b) < 20 || string(b[0:4]) != "RIFF" || string(b[8:16]) != "WEBPVP8L" {
+		return nil, errors.New("webp: not a lossless webp")
+	}
+
+	r := &bitReader{data: b[20 : 20+binary.LittleEndian.Uint32(b[16:])]}
+	var header [8]uint32
+
+	// signature, width, height, alpha, version, transform, subtract green
+	// and no more transforms, color cache and meta prefix codes.
+	for i, n := range []uint{8, 14, 14, 1, 3, 1, 2, 1} {
+		header[i], _ = r.read(n)
+	}
+
+	if header[0] != 0x2f || header[5] != 1 || header[6] != 2 || header[7] != 0 {
+		return nil, fmt.Errorf("webp: unexpected header %v", header)
+	}
+
+	if v, err := r.read(2); err != nil || v != 0 {
+		return nil, errors.New("webp: color cache and meta codes aren't written")
+	}
+
+	width, height := int(header[1])+1, int(header[2])+1
+	var codes [5]testCode
+
+	for i, alphabet := range []int{256 + 24, 256, 256, 256, 40} {
+		lengths, err := r.codeLengths(alphabet)
+
+		if err != nil {
+			return nil, err
+		}
+
+		codes[i] = newTestCode(lengths)
+	}
+
+	pixels := make([]uint32, width*height)
+
+	for i := 0; i < len(pixels); {
+		g, err := codes[0].symbol(r)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if g < 256 {
+			red, _ := codes[1].symbol(r)
+			blue, _ := codes[2].symbol(r)
+			alpha, err := codes[3].symbol(r)
+
+			if err != nil {
+				return nil, err
+			}
+
+			pixels[i] = uint32(alpha)<<24 | uint32(red)<<16 | uint32(g)<<8 | uint32(blue)
+			i++
+			continue
+		}
+
+		length, _ := r.prefixValue(g - 256)
+		code, _ := codes[4].symbol(r)
+		plane, err := r.prefixValue(code)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// only the pixel above and the one to the left are referenced
+		distance := map[int]int{1: width, 2: 1}[plane]
+
+		if distance == 0 || i < distance || i+length > len(pixels) {
+			return nil, fmt.Errorf("webp: invalid backward reference %d,%d at %d", plane, length, i)
+		}
+
+		for j := 0; j < length; j++ {
+			pixels[i+j] = pixels[i+j-distance]
+		}
+
+		i += length
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for i, p := range pixels {
+		g := uint8(p >> 8)
+		img.SetNRGBA(i%width, i/width, color.NRGBA{uint8(p>>16) + g, g, uint8(p) + g, uint8(p >> 24)})
+	}
+
+	return img, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+type (
+	geojsonEncoding struct{}
+
+	geojsonCollection struct {
+		Type     string           `json:"type"`
+		Features []geojsonFeature `json:"features"`
+	}
+	geojsonFeature struct {
+		Type       string           `json:"type"`
+		BBox       []float64        `json:"bbox,omitempty"`
+		Geometry   *geojsonGeometry `json:"geometry"`
+		Properties geojsonProps     `json:"properties"`
+	}
+	geojsonGeometry struct {
+		Type        string      `json:"type"`
+		Coordinates interface{} `json:"coordinates"`
+	}
+	geojsonProps struct {
+		Query    string `json:"query"`
+		Address  string `json:"address"`
+		Street   string `json:"street,omitempty"`
+		Country  string `json:"country"`
+		City     string `json:"city,omitempty"`
+		Zip      string `json:"zip,omitempty"`
+		State    string `json:"state,omitempty"`
+		Provider string `json:"provider,omitempty"`
+		Error    string `json:"error,omitempty"`
+	}
+)
+
+// Marshal encodes the results as a FeatureCollection of Points, results
+// with bounds gets an additional Polygon feature.
+func (e geojsonEncoding) Marshal(v interface{}, pretty bool) ([]byte, error) {
+	results, err := toResults(v)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	c := geojsonCollection{Type: "FeatureCollection", Features: []geojsonFeature{}}
+
+	for _, r := range results {
+		props := geojsonProps{
+			Query:    r.Query,
+			Address:  r.Address,
+			Street:   r.Street,
+			Country:  r.Country,
+			City:     r.City,
+			Zip:      r.Zip,
+			State:    r.State,
+			Provider: r.Provider,
+			Error:    r.Error,
+		}
+
+		// failed lookups have no location, and gets a null geometry.
+		if len(r.Error) > 0 {
+			c.Features = append(c.Features, geojsonFeature{Type: "Feature", Properties: props})
+			continue
+		}
+
+		c.Features = append(c.Features, geojsonFeature{
+			Type:       "Feature",
+			Geometry:   &geojsonGeometry{"Point", geojsonPosition(r.Location)},
+			Properties: props,
+		})
+
+		if b := r.Bounds; b != nil {
+			sw, ne := b.SouthWest, b.NorthEast
+			nw := geo.Location{Latitude: ne.Latitude, Longitude: sw.Longitude}
+			se := geo.Location{Latitude: sw.Latitude, Longitude: ne.Longitude}
+			ring := [][]float64{
+				geojsonPosition(sw), geojsonPosition(se), geojsonPosition(ne),
+				geojsonPosition(nw), geojsonPosition(sw),
+			}
+
+			c.Features = append(c.Features, geojsonFeature{
+				Type:       "Feature",
+				BBox:       []float64{sw.Longitude, sw.Latitude, ne.Longitude, ne.Latitude},
+				Geometry:   &geojsonGeometry{"Polygon", [][][]float64{ring}},
+				Properties: props,
+			})
+		}
+	}
+
+	if pretty {
+		return json.MarshalIndent(&c, "", "  ")
+	}
+	return json.Marshal(&c)
+}
+
+// geojsonPosition returns the location as [longitude, latitude].
+func geojsonPosition(l geo.Location) []float64 {
+	return []float64{l.Longitude, l.Latitude}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+func TestGeoJSONEncoding(t *testing.T) {
+	results := []geo.Result{
+		{
+			Query:    "vigerslev alle 77, valby",
+			Address:  "Vigerslev Allé 77, 2500 Valby",
+			Country:  "Denmark",
+			Location: geo.Location{Latitude: 55.6645, Longitude: 12.5048},
+			Bounds: &geo.Bounds{
+				SouthWest: geo.Location{Latitude: 55.66, Longitude: 12.50},
+				NorthEast: geo.Location{Latitude: 55.67, Longitude: 12.51},
+			},
+			Provider: "google",
+		},
+		{Query: "nowhere", Provider: "google", Error: "result: ZERO_RESULTS"},
+	}
+
+	b, err := Marshal("geojson", &results, false)
+	assert.Nil(t, err)
+
+	var c struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Type     string    `json:"type"`
+			BBox     []float64 `json:"bbox"`
+			Geometry *struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]string `json:"properties"`
+		} `json:"features"`
+	}
+	assert.Nil(t, json.Unmarshal(b, &c))
+	assert.Equal(t, "FeatureCollection", c.Type)
+	assert.Len(t, c.Features, 3)
+
+	// positions are [longitude, latitude]
+	point := c.Features[0]
+	assert.Equal(t, "Feature", point.Type)
+	assert.Equal(t, "Point", point.Geometry.Type)
+	assert.Equal(t, "[12.5048,55.6645]", string(point.Geometry.Coordinates))
+	assert.Nil(t, point.BBox)
+	assert.Equal(t, "vigerslev alle 77, valby", point.Properties["query"])
+	assert.Equal(t, "google", point.Properties["provider"])
+
+	// the bounds are a closed ring from the south-west corner, and the
+	// bbox is [west, south, east, north]
+	bounds := c.Features[1]
+	assert.Equal(t, "Polygon", bounds.Geometry.Type)
+	assert.Equal(t, "[[[12.5,55.66],[12.51,55.66],[12.51,55.67],[12.5,55.67],[12.5,55.66]]]", string(bounds.Geometry.Coordinates))
+	assert.Equal(t, []float64{12.50, 55.66, 12.51, 55.67}, bounds.BBox)
+
+	// a failed lookup has a null geometry
+	failed := c.Features[2]
+	assert.Nil(t, failed.Geometry)
+	assert.Equal(t, "result: ZERO_RESULTS", failed.Properties["error"])
+
+	empty := []geo.Result{}
+	b, err = Marshal("geojson", &empty, false)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"type":"FeatureCollection","features":[]}`, string(b))
+}
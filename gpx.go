@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/xml"
+)
+
+type (
+	gpxEncoding struct{}
+
+	gpxDocument struct {
+		XMLName   xml.Name      `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+		Version   string        `xml:"version,attr"`
+		Creator   string        `xml:"creator,attr"`
+		Waypoints []gpxWaypoint `xml:"wpt"`
+	}
+	gpxWaypoint struct {
+		Latitude    float64 `xml:"lat,attr"`
+		Longitude   float64 `xml:"lon,attr"`
+		Name        string  `xml:"name"`
+		Description string  `xml:"desc,omitempty"`
+	}
+)
+
+// Marshal encodes the results as GPX waypoints, failed lookups are
+// skipped as a waypoint needs a location.
+func (e gpxEncoding) Marshal(v interface{}, pretty bool) ([]byte, error) {
+	results, err := toResults(v)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	doc := gpxDocument{Version: "1.1", Creator: "gogeo"}
+
+	for _, r := range results {
+		if len(r.Error) > 0 {
+			continue
+		}
+
+		doc.Waypoints = append(doc.Waypoints, gpxWaypoint{
+			Latitude:    r.Latitude,
+			Longitude:   r.Longitude,
+			Name:        resultName(r),
+			Description: resultDescription(r),
+		})
+	}
+
+	return marshalXMLDocument(&doc, pretty)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGPXEncoding(t *testing.T) {
+	b, err := Marshal("gpx", &placemarkTestResults, false)
+	assert.Nil(t, err)
+
+	// failed lookups are skipped, a waypoint needs a location
+	assert.Equal(t, xml.Header+`<gpx xmlns="http://www.topografix.com/GPX/1/1" version="1.1" creator="gogeo">`+
+		`<wpt lat="55.6737" lon="12.5681"><name>Tivoli &lt;Gardens&gt; &amp; &#34;Friends&#34;</name>`+
+		`<desc>query: tivoli&#xA;provider: google</desc></wpt>`+
+		`</gpx>`, string(b))
+
+	var doc gpxDocument
+	assert.Nil(t, xml.Unmarshal(b, &doc))
+	assert.Equal(t, `Tivoli <Gardens> & "Friends"`, doc.Waypoints[0].Name)
+	assert.Equal(t, 55.6737, doc.Waypoints[0].Latitude)
+	assert.Equal(t, 12.5681, doc.Waypoints[0].Longitude)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+
+	"github.com/harboe/gogeo/geo"
+	"github.com/harboe/gogeo/geo/middleware"
+)
+
+type (
+	// providersStatus is the body of /providers
+	providersStatus struct {
+		XMLName   xml.Name         `json:"-" yaml:"-" xml:"providers"`
+		Providers []providerStatus `json:"providers" yaml:"providers" xml:"provider"`
+	}
+	providerStatus struct {
+		Name         string   `json:"name" yaml:"name" xml:"name,attr"`
+		Capabilities []string `json:"capabilities" yaml:"capabilities" xml:"capability"`
+		RequiresKey  bool     `json:"requires_key" yaml:"requires_key" xml:"requires_key"`
+		Key          bool     `json:"key" yaml:"key" xml:"key"`
+		Ready        bool     `json:"ready" yaml:"ready" xml:"ready"`
+		Breaker      string   `json:"breaker" yaml:"breaker" xml:"breaker"`
+		Requests     int      `json:"requests" yaml:"requests" xml:"requests"`
+		ErrorRate    float64  `json:"error_rate" yaml:"error_rate" xml:"error_rate"`
+	}
+)
+
+// statusOf returns the status of every registered provider, a provider is
+// ready when it's constructed and has a key, if it needs one. The local
+// provider is ready when its tile source opens.
+func statusOf() providersStatus {
+	s := providersStatus{Providers: []providerStatus{}}
+
+	for _, name := range geo.Providers() {
+		p := providerStatus{
+			Name:         name,
+			Capabilities: geo.Capabilities(name),
+			RequiresKey:  geo.RequiresKey(name),
+			Key:          len(config.Get(name+".key")) > 0,
+			Breaker:      middleware.BreakerClosed,
+		}
+		p.Ready = providers[name] != nil && (p.Key || !p.RequiresKey) && geo.Ready(providers[name]) == nil
+
+		if b := config.breakers[name]; b != nil {
+			p.Breaker = b.State()
+			p.ErrorRate, p.Requests = b.ErrorRate()
+		}
+
+		s.Providers = append(s.Providers, p)
+	}
+
+	return s
+}
+
+// healthzHandler reports the process is up.
+func healthzHandler(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyzHandler reports whether the providers are constructed, and at
+// least one of them is ready to serve requests. Providers missing a key
+// are listed as not ready.
+func readyzHandler(w http.ResponseWriter, req *http.Request) {
+	status, ready := http.StatusServiceUnavailable, map[string]bool{}
+
+	for _, p := range statusOf().Providers {
+		ready[p.Name] = p.Ready
+
+		if p.Ready {
+			status = http.StatusOK
+		}
+	}
+
+	body := map[string]interface{}{"status": "ready", "providers": ready}
+
+	if status != http.StatusOK {
+		body["status"] = "not ready"
+	}
+
+	writeJSON(w, status, body)
+}
+
+// providersHandler lists the providers, in the format of the format query
+// parameter: json, yml or xml.
+func providersHandler(w http.ResponseWriter, req *http.Request) {
+	qry := req.URL.Query()
+	format := qry.Get("format")
+
+	switch format {
+	case "":
+		format = "json"
+	case "json", "yml", "xml":
+	default:
+		writeError(w, http.StatusBadRequest, "format: expected json, yml or xml")
+		return
+	}
+
+	_, pretty := qry["pretty"]
+	s := statusOf()
+	b, err := Marshal(format, &s, pretty)
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType(format))
+	w.Write(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(b)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harboe/gogeo/geo"
+	"github.com/harboe/gogeo/geo/middleware"
+)
+
+func TestProvidersBreaker(t *testing.T) {
+	local, _ := geo.New("local")
+	providers = map[string]geo.Provider{"local": local}
+	b := middleware.NewBreaker(1, time.Hour)
+	config.breakers = map[string]*middleware.Breaker{"local": b}
+	defer func() { config.breakers = nil }()
+
+	// a failed request opens the breaker, which /providers reports
+	f := b.Fetcher(geo.FetcherFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusBadGateway, Body: http.NoBody, Request: req}, nil
+	}))
+	req, _ := http.NewRequest("GET", "http://localhost/geo", nil)
+	f.Do(req)
+
+	w := httptest.NewRecorder()
+	providersHandler(w, httptest.NewRequest("GET", "/providers", nil))
+
+	var s providersStatus
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &s))
+
+	for _, p := range s.Providers {
+		if p.Name == "local" {
+			assert.Equal(t, middleware.BreakerOpen, p.Breaker)
+			assert.Equal(t, 1.0, p.ErrorRate)
+			assert.Equal(t, 1, p.Requests)
+		} else {
+			assert.Equal(t, middleware.BreakerClosed, p.Breaker, p.Name)
+		}
+	}
+}
+
+func TestHealthHandlers(t *testing.T) {
+	local, _ := geo.New("local", geo.Config{Tiles: "geo/testdata/tiles.mbtiles"})
+	providers = map[string]geo.Provider{"local": local}
+	config.breakers = map[string]*middleware.Breaker{"local": middleware.NewBreaker(5, time.Second)}
+
+	w := httptest.NewRecorder()
+	healthzHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var ready struct {
+		Status    string          `json:"status"`
+		Providers map[string]bool `json:"providers"`
+	}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &ready))
+	assert.Equal(t, "ready", ready.Status)
+	assert.True(t, ready.Providers["local"])
+	assert.False(t, ready.Providers["mapquest"])
+
+	w = httptest.NewRecorder()
+	providersHandler(w, httptest.NewRequest("GET", "/providers", nil))
+
+	var s providersStatus
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &s))
+	assert.Equal(t, len(geo.Providers()), len(s.Providers))
+
+	for _, p := range s.Providers {
+		if p.Name == "local" {
+			assert.Equal(t, []string{"reverse", "image"}, p.Capabilities)
+			assert.Equal(t, "closed", p.Breaker)
+			assert.True(t, p.Ready)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	providersHandler(w, httptest.NewRequest("GET", "/providers?format=xml", nil))
+	assert.Equal(t, "application/xml; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.True(t, strings.Contains(w.Body.String(), `<provider name="local"><capability>reverse</capability>`), w.Body.String())
+
+	w = httptest.NewRecorder()
+	providersHandler(w, httptest.NewRequest("GET", "/providers?format=yml", nil))
+	assert.True(t, strings.Contains(w.Body.String(), "- name: local\n"), w.Body.String())
+
+	w = httptest.NewRecorder()
+	providersHandler(w, httptest.NewRequest("GET", "/providers?format=csv", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestReadyzNotReady(t *testing.T) {
+	defer os.Setenv("GOGEO_TILES", os.Getenv("GOGEO_TILES"))
+	os.Unsetenv("GOGEO_TILES")
+
+	type readiness struct {
+		Status    string          `json:"status"`
+		Providers map[string]bool `json:"providers"`
+	}
+
+	for _, tiles := range []string{"", "geo/testdata/missing.mbtiles"} {
+		local, _ := geo.New("local", geo.Config{Tiles: tiles})
+		providers = map[string]geo.Provider{"local": local}
+
+		// the local provider without a tile source can't render maps, and
+		// the others have no key, so nothing is usable.
+		w := httptest.NewRecorder()
+		readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, tiles)
+
+		var ready readiness
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &ready))
+		assert.Equal(t, "not ready", ready.Status)
+		assert.False(t, ready.Providers["local"], tiles)
+
+		for _, p := range statusOf().Providers {
+			assert.False(t, p.Ready, p.Name)
+		}
+	}
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -13,115 +13,476 @@ import (
 	"github.com/justinas/alice"
 
 	"github.com/harboe/gogeo/geo"
+	"github.com/harboe/gogeo/geo/middleware"
 )
 
-func RestService(port string) {
+// route is a path registered by restHandler, and its handler.
+type route struct {
+	path    string
+	handler interface{}
+}
+
+var (
+	// templates loaded at startup, by name
+	templates = map[string]executor{}
+	// providers are created once at startup and shared by all requests
+	providers = map[string]geo.Provider{}
+	// routes registered by restHandler
+	routes []route
+	// geoFormats are the encodings served at /:name/:format
+	geoFormats = []string{"json", "xml", "yml", "geojson", "csv", "tsv", "kml", "gpx", "ndjson"}
+)
+
+// RestService serves the rest api on port, until it's interrupted.
+func RestService(port string) error {
+	h, err := restHandler()
+
+	if err != nil {
+		return err
+	}
+
+	return serve(port, h)
+}
+
+// restHandler creates the providers, and returns the routes wrapped in
+// the middlewares.
+func restHandler() (http.Handler, error) {
+	for _, name := range geo.Providers() {
+		p, err := config.NewWithKey(name, "")
+
+		if err != nil {
+			return nil, err
+		}
+
+		providers[name] = p
+	}
+
 	router := httprouter.New()
-	router.GET("/:name/png", imgHandler)
-	router.GET("/:name/json", geoHandler)
-	router.GET("/:name/xml", geoHandler)
-	router.GET("/:name/yml", geoHandler)
+	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeError(w, http.StatusNotFound, "not found: "+req.URL.Path)
+	})
+	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed: "+req.Method)
+	})
+	// static paths would conflict with the :name wildcard in the router.
+	mux := http.NewServeMux()
+	mux.Handle("/", router)
+
+	// routes records the paths and handlers, so the openapi spec can be
+	// checked. HEAD is served by the same handlers, the server drops the
+	// body.
+	routes = nil
+	get := func(path string, h httprouter.Handle) {
+		router.GET(path, h)
+		router.HEAD(path, h)
+		routes = append(routes, route{path: path, handler: h})
+	}
+	static := func(path string, h http.Handler) {
+		mux.Handle(path, h)
+		routes = append(routes, route{path: path, handler: h})
+	}
+
+	for _, f := range geoFormats {
+		get("/:name/"+f, geoHandler)
+	}
 
-	fmt.Println("route=GET /:name/:format[png,json,xml,yml]")
+	get("/:name/geocode", geocodeHandler)
+	get("/:name/reverse", reverseHandler)
+	get("/:name/template/:template", templateHandler)
+	get("/:name/jpeg", imgHandler)
+
+	for _, f := range geo.Formats() {
+		get("/:name/"+f, imgHandler)
+	}
+
+	static("/results.xsd", http.HandlerFunc(xsdHandler))
+	static("/metrics", middleware.DefaultRegistry)
+	static("/healthz", http.HandlerFunc(healthzHandler))
+	static("/readyz", http.HandlerFunc(readyzHandler))
+	static("/providers", http.HandlerFunc(providersHandler))
+	static("/openapi.json", http.HandlerFunc(openAPIHandler))
+	static("/docs", http.HandlerFunc(docsHandler))
+
+	fmt.Println("route=GET /:name/:format[json,xml,yml,geojson,csv,tsv,kml,gpx,ndjson]")
+	fmt.Println("route=GET /:name/:format[png,jpg,gif,webp]")
+	fmt.Println("route=GET /:name/geocode, /:name/reverse")
+	fmt.Println("route=GET /:name/template/:template")
+	fmt.Println("route=GET /results.xsd")
+	fmt.Println("route=GET /metrics")
+	fmt.Println("route=GET /healthz")
+	fmt.Println("route=GET /readyz")
+	fmt.Println("route=GET /providers?format=[json,yml,xml]")
+	fmt.Println("route=GET /openapi.json")
+	fmt.Println("route=GET /docs")
 
 	routeHandler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		router.ServeHTTP(w, req)
+		mux.ServeHTTP(w, req)
 	})
 
-	chain := alice.New(loggingHandler, corsHandlers).Then(routeHandler)
+	chain := alice.New(tracingHandler, metricsHandler, loggingHandler)
+
+	if server.Compress.Enabled() {
+		chain = chain.Append(server.Compress.Handler)
+	}
+
+	chain = chain.Append(server.CORS.Handler)
 
-	log.Fatal(http.ListenAndServe(port, chain))
+	if len(server.Auth) > 0 {
+		auth, err := loadAuth(server.Auth)
+
+		if err != nil {
+			return nil, fmt.Errorf("auth: %v", err)
+		}
+
+		chain = chain.Append(auth.Handler)
+	}
+
+	if server.Limit.Enabled() {
+		chain = chain.Append(server.Limit.Handler)
+		static("/stats", http.HandlerFunc(server.Limit.statsHandler))
+		fmt.Println("route=GET /stats")
+	}
+
+	return chain.Then(routeHandler), nil
 }
 
+// tracingHandler starts the server span of the request, which continues
+// the trace of the traceparent header.
+func tracingHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		route := routeName(req.URL.Path)
+		ctx, span := middleware.DefaultTracer.StartRemote(req.Context(), req.Method+" "+route, req.Header.Get("traceparent"))
+
+		if span == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, req.WithContext(ctx))
+
+		span.SetAttribute("http.method", req.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.status_code", strconv.Itoa(sw.Status()))
+		span.Finish(nil)
+	})
+}
+
+// loggingHandler logs each request, with the request id of the
+// X-Request-ID header, or a new one. The request id is passed on to the
+// provider requests, and returned in the response.
 func loggingHandler(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		t1 := time.Now()
-		next.ServeHTTP(w, r)
-		t2 := time.Now()
-		log.Printf("[%s] %q %v\n", r.Method, r.URL.String(), t2.Sub(t1))
+		start := time.Now()
+		id := r.Header.Get(middleware.RequestIDHeader)
+
+		if !validRequestID(id) {
+			id = middleware.NewRequestID()
+		}
+
+		w.Header().Set(middleware.RequestIDHeader, id)
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(middleware.WithRequestID(r.Context(), id)))
+
+		fields := middleware.Fields{
+			"request_id":  id,
+			"method":      r.Method,
+			"route":       routeName(r.URL.Path),
+			"url":         geo.RedactURL(r.URL.String()),
+			"status":      sw.Status(),
+			"bytes":       sw.bytes,
+			"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+		}
+
+		if name, _ := routeOf(r.URL.Path); providers[name] != nil {
+			fields["provider"] = name
+		}
+
+		level := middleware.LevelInfo
+		if sw.Status() >= 500 {
+			level = middleware.LevelError
+		}
+
+		middleware.DefaultLog.Print(level, "request", fields)
 	}
 
 	return http.HandlerFunc(fn)
 }
 
-func corsHandlers(next http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, req *http.Request) {
-
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers",
-			"Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+// validRequestID accepts ids of up to 128 letters, digits and -_.:
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
 
-		// Stop here if its Preflighted OPTIONS request
-		if req.Method == "OPTIONS" {
-			return
+	for _, c := range id {
+		if !strings.ContainsRune("-_.:", c) && !('a' <= c && c <= 'z') && !('A' <= c && c <= 'Z') && !('0' <= c && c <= '9') {
+			return false
 		}
-		next.ServeHTTP(w, req)
 	}
-	return http.HandlerFunc(fn)
+
+	return true
 }
 
 func geoHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	qry := req.URL.Query()
-	provider, err := config.NewWithKey(ps.ByName("name"), qry.Get("key"))
-	// provider, err := geo.New(ps.ByName("name"), geo.Config{APIKey: qry.Get("key")})
+	format := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+	writeResults(w, req, ps.ByName("name"), format, address(qry), location(qry))
+}
 
-	if err != nil {
-		w.Write([]byte(err.Error()))
+// geocodeHandler geocodes the addr parameters, in the encoding of the
+// Accept header.
+func geocodeHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	format, ok := negotiate(w, req)
+
+	if !ok {
 		return
 	}
 
-	var results []geo.Result
+	addrs := address(req.URL.Query())
+
+	if len(addrs) == 0 {
+		writeError(w, http.StatusBadRequest, "addr: missing address to geocode")
+		return
+	}
+
+	writeResults(w, req, ps.ByName("name"), format, addrs, nil)
+}
+
+// reverseHandler reverse geocodes the loc parameters, in the encoding of
+// the Accept header.
+func reverseHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	format, ok := negotiate(w, req)
+
+	if !ok {
+		return
+	}
+
+	var locs []geo.Location
 
-	for _, l := range location(qry) {
-		if r, err := provider.Location(l); err == nil {
-			results = append(results, r)
+	for _, l := range req.URL.Query()["loc"] {
+		loc, err := geo.NewLocation(l)
+
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("loc: %v", err))
+			return
 		}
+
+		locs = append(locs, loc)
 	}
 
-	for _, a := range address(qry) {
-		log.Println("address:", a)
-		if r, err := provider.Address(a); err == nil {
-			results = append(results, r)
+	if len(locs) == 0 {
+		writeError(w, http.StatusBadRequest, "loc: missing location to reverse geocode")
+		return
+	}
+
+	writeResults(w, req, ps.ByName("name"), format, nil, locs)
+}
+
+// negotiate returns the encoder of the Accept header, or writes a 406 if
+// none are acceptable. The response varies by the Accept header, unlike the
+// legacy routes, where the format is in the path.
+func negotiate(w http.ResponseWriter, req *http.Request) (string, bool) {
+	w.Header().Add("Vary", "Accept")
+	format, ok := Negotiate(req.Header.Get("Accept"))
+
+	if !ok {
+		var types []string
+		for _, m := range mediaTypes {
+			types = append(types, m.Type)
 		}
+
+		writeError(w, http.StatusNotAcceptable, "not acceptable: expected one of "+strings.Join(types, ", "))
 	}
 
+	return format, ok
+}
+
+// writeResults looks up the addresses and locations, and writes the
+// results in format.
+func writeResults(w http.ResponseWriter, req *http.Request, name, format string, addrs []string, locs []geo.Location) {
+	qry := req.URL.Query()
+	provider := providerFor(w, req, name)
+
+	if provider == nil {
+		return
+	}
+
+	keep := keepErrors(format)
+
+	// stream encodings are written in chunks, as each lookup completes, so
+	// they don't have an ETag, and aren't cached. The write timeout is a
+	// deadline for the whole response, so it's extended after each chunk.
+	if e, ok := encoders[format].(StreamEncoding); ok {
+		w.Header().Set("Content-Type", ContentType(format))
+		w.Header().Set("Cache-Control", cacheControl(req, -1))
+		enc := e.NewEncoder(w)
+		rc := http.NewResponseController(w)
+
+		lookup(provider, name, addrs, locs, func(r geo.Result) error {
+			if len(r.Error) > 0 && !keep {
+				return nil
+			}
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+			rc.Flush()
+			if server.WriteTimeout > 0 {
+				rc.SetWriteDeadline(time.Now().Add(server.WriteTimeout))
+			}
+			return nil
+		})
+		return
+	}
+
+	var results []geo.Result
+	age := server.MaxAge.Geo
+
+	// failed lookups are only in the csv/tsv error column, and not cached.
+	lookup(provider, name, addrs, locs, func(r geo.Result) error {
+		if len(r.Error) > 0 {
+			age = -1
+			if !keep {
+				return nil
+			}
+		}
+		results = append(results, r)
+		return nil
+	})
+
 	_, pretty := qry["pretty"]
-	format := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
-	b, err := Marshal(format, &results, pretty)
+	b, err := marshalDelimited(format, &results, pretty,
+		qry.Get("delimiter"), qry.Get("header") != "false")
 
 	if err != nil {
-		w.Write([]byte(err.Error()))
+		writeError(w, http.StatusInternalServerError, err.Error())
 	} else {
-		w.Write(b)
+		writeCached(w, req, age, ContentType(format), b)
 	}
 }
 
-func imgHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+func templateHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	qry := req.URL.Query()
-	geo, err := config.NewWithKey(ps.ByName("name"), qry.Get("key"))
+	t, ok := templates[ps.ByName("template")]
+
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("not found: template %s", ps.ByName("template")))
+		return
+	}
+
+	provider := providerFor(w, req, ps.ByName("name"))
+
+	if provider == nil {
+		return
+	}
+
+	var results []geo.Result
+	age := server.MaxAge.Template
+
+	lookup(provider, ps.ByName("name"), address(qry), location(qry), func(r geo.Result) error {
+		if len(r.Error) > 0 {
+			age = -1
+			return nil
+		}
+		results = append(results, r)
+		return nil
+	})
+
+	_, all := qry["all"]
+	b, err := templateEncoding{Template: t, All: all}.Marshal(&results, false)
 
 	if err != nil {
-		w.Write([]byte(err.Error()))
+		writeError(w, http.StatusInternalServerError, err.Error())
+	} else {
+		writeCached(w, req, age, templateContentType(ps.ByName("template")), b)
+	}
+}
+
+func imgHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	qry := req.URL.Query()
+	provider := providerFor(w, req, ps.ByName("name"))
+
+	if provider == nil {
 		return
 	}
 
 	opts := mapOptions(qry)
+	opts.Format, _ = geo.NewFormat(req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:])
+
+	if err := opts.Valid(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	markers := address(qry)
 
 	for _, loc := range location(qry) {
 		markers = append(markers, loc.String())
 	}
 
-	b, err := geo.Image(markers, opts)
+	b, err := provider.Image(markers, opts)
 
 	if err != nil {
-		w.Write([]byte(err.Error()))
+		writeError(w, http.StatusBadGateway, err.Error())
 	} else {
-		w.Write(b)
+		writeCached(w, req, server.MaxAge.Image, geo.ContentType(opts.Format), b)
 	}
 }
 
+// providerFor returns the shared provider for name, or a new provider using
+// the key query parameter, if the server allows keys to be overridden.
+// The error is written to w, if there is no provider.
+func providerFor(w http.ResponseWriter, req *http.Request, name string) geo.Provider {
+	key := req.URL.Query().Get("key")
+
+	if len(key) > 0 {
+		if !server.AllowKey {
+			writeError(w, http.StatusForbidden, "key: overriding the provider key is not allowed")
+			return nil
+		}
+
+		p, err := config.NewWithKey(name, key)
+
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return nil
+		}
+
+		return middleware.TraceProvider(req.Context(), strings.ToLower(name), p)
+	}
+
+	p, ok := providers[strings.ToLower(name)]
+
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("not found: %s", name))
+		return nil
+	}
+
+	return middleware.TraceProvider(req.Context(), strings.ToLower(name), p)
+}
+
+// writeError writes the error envelope, which is the body of every error
+// response: {"error":{"status":404,"message":"not found: name"}}
+func writeError(w http.ResponseWriter, status int, msg string) {
+	var e struct {
+		Error struct {
+			Status  int    `json:"status"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	e.Error.Status, e.Error.Message = status, msg
+	b, _ := json.Marshal(e)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func xsdHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write(resultsXSD)
+}
+
 func mapOptions(qry url.Values) (opts geo.MapOptions) {
 	opts.Size, _ = geo.NewSize(qry.Get("size"))
 
@@ -133,6 +494,12 @@ func mapOptions(qry url.Values) (opts geo.MapOptions) {
 		opts.Scale, _ = strconv.ParseUint(s, 0, 10)
 	}
 
+	for _, p := range qry["path"] {
+		if loc, err := geo.NewLocation(p); err == nil {
+			opts.Path = append(opts.Path, loc.String())
+		}
+	}
+
 	return
 }
 
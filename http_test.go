@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harboe/gogeo/geo"
+	"github.com/harboe/gogeo/geo/middleware"
+)
+
+func TestProviderFor(t *testing.T) {
+	local, _ := geo.New("local")
+	providers = map[string]geo.Provider{"local": local}
+	defer func() { server.AllowKey = false }()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/Local/json", nil)
+	assert.Equal(t, local, providerFor(w, req, "Local"))
+
+	w = httptest.NewRecorder()
+	assert.Nil(t, providerFor(w, req, "unknown"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// the key override is only allowed if enabled
+	req = httptest.NewRequest("GET", "/local/json?key=secret", nil)
+	w = httptest.NewRecorder()
+	assert.Nil(t, providerFor(w, req, "local"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	server.AllowKey = true
+	w = httptest.NewRecorder()
+	p := providerFor(w, req, "local")
+	assert.NotNil(t, p)
+	assert.True(t, p != local, "expected a new provider with the key")
+}
+
+func TestTracing(t *testing.T) {
+	var traceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		traceparent = req.Header.Get("traceparent")
+		w.Write([]byte(`{"status":"ZERO_RESULTS","results":[]}`))
+	}))
+	defer backend.Close()
+
+	var buf bytes.Buffer
+	middleware.DefaultTracer = middleware.NewTracer(&buf)
+	defer func() { middleware.DefaultTracer = nil }()
+
+	f := middleware.Tracing(http.DefaultClient, "google")
+	google, _ := geo.New("google", geo.Config{Fetcher: f, GeoURL: backend.URL})
+	providers = map[string]geo.Provider{"google": google}
+
+	h := tracingHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		providerFor(w, req, "google").Address("nowhere")
+	}))
+
+	req := httptest.NewRequest("GET", "/google/json?addr=nowhere", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var spans []middleware.Span
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var s middleware.Span
+		assert.Nil(t, dec.Decode(&s))
+		spans = append(spans, s)
+	}
+
+	// spans are exported as they finish: fetch, provider and server
+	assert.Equal(t, 3, len(spans))
+	fetch, provider, server := spans[0], spans[1], spans[2]
+
+	assert.Equal(t, "GET /:name/json", server.Name)
+	assert.Equal(t, "00f067aa0ba902b7", server.ParentID)
+	assert.Equal(t, "google geocode", provider.Name)
+	assert.Equal(t, "geocode", provider.Attributes["query.type"])
+	assert.Equal(t, "error", provider.Status)
+	assert.Equal(t, server.SpanID, provider.ParentID)
+	assert.Equal(t, provider.SpanID, fetch.ParentID)
+	assert.Equal(t, "200", fetch.Attributes["http.status_code"])
+
+	for _, s := range spans {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.TraceID)
+	}
+
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+fetch.SpanID+"-01", traceparent)
+}
+
+func TestLogging(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"status":"ZERO_RESULTS","results":[]}`))
+	}))
+	defer backend.Close()
+
+	var buf bytes.Buffer
+	log := middleware.DefaultLog
+	middleware.DefaultLog = middleware.NewLog(&buf, middleware.LevelDebug, true)
+	defer func() { middleware.DefaultLog = log }()
+
+	f := middleware.Logger(http.DefaultClient, "google")
+	google, _ := geo.New("google", geo.Config{Fetcher: f, GeoURL: backend.URL, APIKey: "AIzaSyabcdwxyz"})
+	providers = map[string]geo.Provider{"google": google}
+
+	h := loggingHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		providerFor(w, req, "google").Address("nowhere")
+		w.Write([]byte("[]"))
+	}))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/google/json?addr=nowhere", nil)
+	req.Header.Set("X-Request-ID", "req-42")
+	h.ServeHTTP(w, req)
+	assert.Equal(t, "req-42", w.Header().Get("X-Request-ID"))
+
+	var entries []map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e map[string]interface{}
+		assert.Nil(t, dec.Decode(&e))
+		entries = append(entries, e)
+	}
+
+	assert.Equal(t, 2, len(entries))
+	fetch, request := entries[0], entries[1]
+
+	assert.Equal(t, "fetch", fetch["msg"])
+	assert.Equal(t, "debug", fetch["level"])
+	assert.Equal(t, "req-42", fetch["request_id"])
+	assert.Equal(t, "google", fetch["provider"])
+	assert.Equal(t, backend.URL+"?address=nowhere&key=****wxyz", fetch["url"])
+
+	assert.Equal(t, "request", request["msg"])
+	assert.Equal(t, "req-42", request["request_id"])
+	assert.Equal(t, "/:name/json", request["route"])
+	assert.Equal(t, "google", request["provider"])
+	assert.Equal(t, float64(200), request["status"])
+	assert.Equal(t, float64(2), request["bytes"])
+
+	// invalid ids are replaced
+	w = httptest.NewRecorder()
+	req.Header.Set("X-Request-ID", "bad id\n")
+	h.ServeHTTP(w, req)
+	assert.Equal(t, 16, len(w.Header().Get("X-Request-ID")))
+}
+
+func TestNegotiatedRoutes(t *testing.T) {
+	local, _ := geo.New("local")
+	providers = map[string]geo.Provider{"local": local}
+
+	h, err := restHandler()
+	assert.Nil(t, err)
+
+	get := func(url, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		if len(accept) > 0 {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/local/reverse?loc=55.676,12.568", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ContentType("json"), w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header()["Vary"], "Accept")
+
+	w = get("/local/reverse?loc=55.676,12.568", "text/csv")
+	assert.Equal(t, ContentType("csv"), w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "55.676")
+
+	w = get("/local/reverse?loc=55.676,12.568", "application/geo+json;q=0.9, application/xml;q=0.5")
+	assert.Equal(t, ContentType("geojson"), w.Header().Get("Content-Type"))
+
+	w = get("/local/reverse?loc=55.676,12.568", "image/png")
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header()["Vary"], "Accept")
+
+	w = get("/local/reverse?loc=north", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = get("/local/geocode", "application/xml")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Header()["Vary"], "Accept")
+
+	// failed lookups are only in the csv/tsv error column
+	w = get("/local/geocode?addr=valby", "application/x-yaml")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ContentType("yml"), w.Header().Get("Content-Type"))
+	assert.Equal(t, "[]\n", w.Body.String())
+
+	w = get("/local/geocode?addr=valby", "text/csv")
+	assert.Contains(t, w.Body.String(), "not supported offline")
+
+	// the legacy routes ignores the Accept header
+	w = get("/local/xml?loc=55.676,12.568", "application/json")
+	assert.Equal(t, ContentType("xml"), w.Header().Get("Content-Type"))
+	assert.NotContains(t, w.Header()["Vary"], "Accept")
+
+	w = get("/local/unknown/route", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+
+	// HEAD is allowed, as the default cors methods advertise
+	for _, url := range []string{"/local/reverse?loc=55.676,12.568", "/local/json?loc=55.676,12.568"} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("HEAD", url, nil))
+		assert.Equal(t, http.StatusOK, w.Code, url)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/local/json?loc=55.676,12.568", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestImageHandler(t *testing.T) {
+	local, _ := geo.New("local", geo.Config{Tiles: "geo/testdata/tiles.mbtiles"})
+	providers = map[string]geo.Provider{"local": local}
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		imgHandler(w, req, httprouter.Params{{Key: "name", Value: "local"}})
+		return w
+	}
+
+	w := get("/local/webp?loc=0,0&zoom=1&size=50x40")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/webp", w.Header().Get("Content-Type"))
+
+	// the size is in the VP8L header, 14 bits each, less one
+	b := w.Body.Bytes()
+	assert.True(t, len(b) > 25)
+	assert.Equal(t, "RIFF", string(b[0:4]))
+	assert.Equal(t, "WEBPVP8L", string(b[8:16]))
+	assert.Equal(t, byte(0x2f), b[20])
+	assert.Equal(t, 50, 1+(int(b[21])|int(b[22]&0x3f)<<8))
+	assert.Equal(t, 40, 1+(int(b[22]>>6)|int(b[23])<<2|int(b[24]&0x0f)<<10))
+
+	// the size, scale and zoom are checked before anything is rendered
+	for _, url := range []string{
+		"/local/png?loc=1,1&size=100000x100000",
+		"/local/png?loc=1,1&size=640x641",
+		"/local/png?loc=1,1&scale=4",
+		"/local/png?loc=1,1&zoom=23",
+	} {
+		w = get(url)
+		assert.Equal(t, http.StatusBadRequest, w.Code, url)
+		assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"), url)
+	}
+
+	w = get("/local/png?loc=1,1&size=640x640&scale=2&zoom=1")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// errors have a content type too, not only images
+	providers["local"], _ = geo.New("local")
+	w = get("/local/png?loc=0,0")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "no tile source")
+}
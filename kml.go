@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+type (
+	kmlEncoding struct{}
+
+	kmlDocument struct {
+		XMLName    xml.Name       `xml:"http://www.opengis.net/kml/2.2 kml"`
+		Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+	}
+	kmlPlacemark struct {
+		Name        string    `xml:"name"`
+		Description string    `xml:"description,omitempty"`
+		Point       *kmlPoint `xml:"Point,omitempty"`
+	}
+	kmlPoint struct {
+		Coordinates string `xml:"coordinates"`
+	}
+)
+
+// Marshal encodes the results as KML placemarks, failed lookups are kept
+// as placemarks without a point.
+func (e kmlEncoding) Marshal(v interface{}, pretty bool) ([]byte, error) {
+	results, err := toResults(v)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	doc := kmlDocument{}
+
+	for _, r := range results {
+		p := kmlPlacemark{Name: resultName(r), Description: resultDescription(r)}
+
+		if len(r.Error) == 0 {
+			p.Point = &kmlPoint{Coordinates: strconv.FormatFloat(r.Longitude, 'f', -1, 64) +
+				"," + strconv.FormatFloat(r.Latitude, 'f', -1, 64)}
+		}
+
+		doc.Placemarks = append(doc.Placemarks, p)
+	}
+
+	return marshalXMLDocument(&doc, pretty)
+}
+
+// resultName is the address of the result, or the query if the address
+// is unknown.
+func resultName(r geo.Result) string {
+	if len(r.Address) > 0 {
+		return r.Address
+	}
+
+	return r.Query
+}
+
+// resultDescription lists the query, provider and error of the result.
+func resultDescription(r geo.Result) string {
+	lines := []string{"query: " + r.Query}
+
+	if len(r.Provider) > 0 {
+		lines = append(lines, "provider: "+r.Provider)
+	}
+	if len(r.Error) > 0 {
+		lines = append(lines, "error: "+r.Error)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// marshalXMLDocument marshals v with a xml declaration.
+func marshalXMLDocument(v interface{}, pretty bool) ([]byte, error) {
+	var b []byte
+	var err error
+
+	if pretty {
+		b, err = xml.MarshalIndent(v, "", "  ")
+	} else {
+		b, err = xml.Marshal(v)
+	}
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return append([]byte(xml.Header), b...), nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+// placemarkTestResults have names, which must be escaped in xml.
+var placemarkTestResults = []geo.Result{
+	{
+		Query:    "tivoli",
+		Address:  `Tivoli <Gardens> & "Friends"`,
+		Location: geo.Location{Latitude: 55.6737, Longitude: 12.5681},
+		Provider: "google",
+	},
+	{Query: "a < b", Provider: "google", Error: "result: ZERO_RESULTS"},
+}
+
+func TestKMLEncoding(t *testing.T) {
+	b, err := Marshal("kml", &placemarkTestResults, false)
+	assert.Nil(t, err)
+
+	// coordinates are longitude,latitude
+	assert.Equal(t, xml.Header+`<kml xmlns="http://www.opengis.net/kml/2.2"><Document>`+
+		`<Placemark><name>Tivoli &lt;Gardens&gt; &amp; &#34;Friends&#34;</name>`+
+		`<description>query: tivoli&#xA;provider: google</description>`+
+		`<Point><coordinates>12.5681,55.6737</coordinates></Point></Placemark>`+
+		// a failed lookup is a placemark without a point
+		`<Placemark><name>a &lt; b</name>`+
+		`<description>query: a &lt; b&#xA;provider: google&#xA;error: result: ZERO_RESULTS</description></Placemark>`+
+		`</Document></kml>`, string(b))
+
+	// it's still well-formed, and the names decodes as they were
+	var doc kmlDocument
+	assert.Nil(t, xml.Unmarshal(b, &doc))
+	assert.Equal(t, `Tivoli <Gardens> & "Friends"`, doc.Placemarks[0].Name)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// limiter rate limits inbound requests by client, the authenticated
+	// client name or the remote ip. Each client has a token bucket of
+	// Burst requests refilled at Rate requests per second, and at most
+	// Daily requests per day (UTC). Zero disables the limit.
+	limiter struct {
+		sync.Mutex
+		Rate  float64
+		Burst int
+		Daily int
+
+		day     string
+		clients map[string]*clientLimit
+	}
+	clientLimit struct {
+		tokens  float64
+		last    time.Time
+		Allowed int `json:"allowed"`
+		Limited int `json:"limited"`
+	}
+	// limitStats is the body of the stats endpoint.
+	limitStats struct {
+		Day     string            `json:"day"`
+		Rate    float64           `json:"rate"`
+		Burst   int               `json:"burst"`
+		Daily   int               `json:"daily"`
+		Clients []clientLimitStat `json:"clients"`
+	}
+	clientLimitStat struct {
+		Client string `json:"client"`
+		clientLimit
+	}
+)
+
+// Enabled reports whether any limit is set.
+func (l *limiter) Enabled() bool {
+	return l.Rate > 0 || l.Daily > 0
+}
+
+// Handler is the alice middleware, requests over the limit gets a 429
+// with Retry-After. Every response has the X-RateLimit-* headers.
+func (l *limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if public(req.URL.Path) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		ok, limit, remaining, reset := l.take(clientID(req), time.Now())
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+		if !ok {
+			retry := int(math.Ceil(reset.Sub(time.Now()).Seconds()))
+
+			if retry < 1 {
+				retry = 1
+			}
+
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			writeError(w, http.StatusTooManyRequests, fmt.Sprintf("too many requests: retry in %ds", retry))
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// take uses a request for the client, and returns whether it's allowed,
+// the limit, remaining requests and when the limit resets. The daily
+// limit is reported when set, otherwise the burst.
+func (l *limiter) take(id string, now time.Time) (ok bool, limit, remaining int, reset time.Time) {
+	l.Lock()
+	defer l.Unlock()
+
+	// the counters restarts every day, which also drops idle clients.
+	if day := now.UTC().Format("2006-01-02"); day != l.day || l.clients == nil {
+		l.day, l.clients = day, map[string]*clientLimit{}
+	}
+
+	c, found := l.clients[id]
+
+	if !found {
+		c = &clientLimit{tokens: float64(l.Burst), last: now}
+		l.clients[id] = c
+	}
+
+	if l.Rate > 0 {
+		c.tokens = math.Min(float64(l.Burst), c.tokens+now.Sub(c.last).Seconds()*l.Rate)
+		c.last = now
+	}
+
+	midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	daily := l.Daily > 0 && c.Allowed >= l.Daily
+	burst := l.Rate > 0 && c.tokens < 1
+
+	if !daily && !burst {
+		c.Allowed++
+		if l.Rate > 0 {
+			c.tokens--
+		}
+	} else {
+		c.Limited++
+	}
+
+	switch {
+	case daily || (l.Daily > 0 && !burst):
+		limit, remaining, reset = l.Daily, l.Daily-c.Allowed, midnight
+	case burst:
+		limit, reset = l.Burst, now.Add(time.Duration((1-c.tokens)/l.Rate*float64(time.Second)))
+	default:
+		limit, remaining = l.Burst, int(c.tokens)
+		reset = now.Add(time.Duration((float64(l.Burst) - c.tokens) / l.Rate * float64(time.Second)))
+	}
+
+	return !daily && !burst, limit, remaining, reset
+}
+
+// Stats returns today's counters of every client.
+func (l *limiter) Stats() limitStats {
+	l.Lock()
+	defer l.Unlock()
+
+	s := limitStats{Day: l.day, Rate: l.Rate, Burst: l.Burst, Daily: l.Daily, Clients: []clientLimitStat{}}
+
+	for id, c := range l.clients {
+		s.Clients = append(s.Clients, clientLimitStat{Client: id, clientLimit: *c})
+	}
+
+	sort.Slice(s.Clients, func(i, j int) bool { return s.Clients[i].Client < s.Clients[j].Client })
+	return s
+}
+
+func (l *limiter) statsHandler(w http.ResponseWriter, req *http.Request) {
+	b, _ := json.Marshal(l.Stats())
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(b)
+}
+
+// clientID is the authenticated client, or the remote ip.
+func clientID(req *http.Request) string {
+	if name := clientName(req); len(name) > 0 {
+		return name
+	}
+
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+
+	return req.RemoteAddr
+}
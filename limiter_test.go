@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterBurst(t *testing.T) {
+	l := &limiter{Rate: 1, Burst: 2}
+	now := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	ok, limit, remaining, _ := l.take("a", now)
+	assert.True(t, ok)
+	assert.Equal(t, 2, limit)
+	assert.Equal(t, 1, remaining)
+
+	ok, _, remaining, _ = l.take("a", now)
+	assert.True(t, ok)
+	assert.Equal(t, 0, remaining)
+
+	ok, _, _, reset := l.take("a", now)
+	assert.False(t, ok)
+	assert.Equal(t, now.Add(time.Second), reset)
+
+	// other clients has their own bucket
+	ok, _, _, _ = l.take("b", now)
+	assert.True(t, ok)
+
+	ok, _, _, _ = l.take("a", now.Add(time.Second))
+	assert.True(t, ok)
+
+	s := l.Stats()
+	assert.Equal(t, "2016-05-01", s.Day)
+	assert.Equal(t, 2, len(s.Clients))
+	assert.Equal(t, "a", s.Clients[0].Client)
+	assert.Equal(t, 3, s.Clients[0].Allowed)
+	assert.Equal(t, 1, s.Clients[0].Limited)
+}
+
+func TestLimiterDaily(t *testing.T) {
+	l := &limiter{Daily: 2, Burst: 10}
+	now := time.Date(2016, 5, 1, 23, 0, 0, 0, time.UTC)
+	midnight := time.Date(2016, 5, 2, 0, 0, 0, 0, time.UTC)
+
+	ok, limit, remaining, reset := l.take("a", now)
+	assert.True(t, ok)
+	assert.Equal(t, 2, limit)
+	assert.Equal(t, 1, remaining)
+	assert.Equal(t, midnight, reset)
+
+	l.take("a", now)
+	ok, _, remaining, _ = l.take("a", now)
+	assert.False(t, ok)
+	assert.Equal(t, 0, remaining)
+
+	// the cap resets the next day
+	ok, _, _, _ = l.take("a", midnight)
+	assert.True(t, ok)
+}
+
+func TestLimiterHandler(t *testing.T) {
+	l := &limiter{Rate: 0.5, Burst: 1}
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/local/json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/local/json", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+}
@@ -1,19 +1,47 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"strings"
+	"path/filepath"
+	"time"
 
 	"github.com/harboe/gogeo/geo"
+	"github.com/harboe/gogeo/geo/middleware"
 	"github.com/spf13/cobra"
 )
 
 var (
 	server struct {
-		Port string
+		Port      string
+		Templates string
+		// AllowKey allows requests to override the provider key with the
+		// key query parameter.
+		AllowKey bool
+		// Auth is the file of clients allowed to use the server, if empty
+		// the server is open to everyone.
+		Auth string
+		// Limit is the inbound rate limit of each client
+		Limit limiter
+		// Socket is a unix socket to listen on, instead of the port
+		Socket string
+		// TLSCert and TLSKey enables https, SIGHUP reloads them
+		TLSCert, TLSKey string
+		ReadTimeout     time.Duration
+		WriteTimeout    time.Duration
+		IdleTimeout     time.Duration
+		// ShutdownTimeout is how long requests in flight have to finish,
+		// on SIGINT or SIGTERM
+		ShutdownTimeout time.Duration
+		// MaxAge is the Cache-Control max-age of the responses
+		MaxAge maxAge
+		// CORS is the cross-origin policy
+		CORS corsPolicy
+		// Compress is the brotli/gzip/deflate compression of the responses
+		Compress compression
 	}
+	force    bool
 	image    imageFlags
 	format   formatFlags
 	addrList addressList
@@ -27,19 +55,62 @@ func main() {
 		Short: "execute a httpserver",
 		Long:  "gogeo: as a rest service",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Printf("rest service ready at http://%s\n", server.Port)
-			RestService(server.Port)
+			if len(server.Templates) > 0 {
+				t, err := loadTemplates(server.Templates)
+
+				if err != nil {
+					fmt.Println("templates:", err)
+					return
+				}
+
+				templates = t
+			}
+
+			if err := RestService(server.Port); err != nil {
+				fmt.Println("http:", err)
+				os.Exit(1)
+			}
 		},
 	}
 	serverCmd.Flags().StringVarP(&server.Port, "port", "p", "localhost:8080", "server listing port")
+	serverCmd.Flags().StringVar(&server.Templates, "templates", "", "directory of output templates, served at /:name/template/:template")
+	serverCmd.Flags().StringVar(&server.Socket, "socket", "", "listen on a unix socket, instead of the port")
+	serverCmd.Flags().StringVar(&server.TLSCert, "tls-cert", "", "certificate file, enables https, reloaded on SIGHUP")
+	serverCmd.Flags().StringVar(&server.TLSKey, "tls-key", "", "private key file of the certificate")
+	serverCmd.Flags().DurationVar(&server.ReadTimeout, "read-timeout", 15*time.Second, "max duration for reading a request")
+	serverCmd.Flags().DurationVar(&server.WriteTimeout, "write-timeout", 5*time.Minute, "max duration for writing a response, 0 is none")
+	serverCmd.Flags().DurationVar(&server.IdleTimeout, "idle-timeout", 2*time.Minute, "max duration of idle keep-alive connections")
+	serverCmd.Flags().DurationVar(&server.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "how long requests in flight have to finish on SIGINT or SIGTERM")
+	serverCmd.Flags().DurationVar(&server.MaxAge.Geo, "max-age-geo", 0, "Cache-Control max-age of geocoding results, 0 must revalidate the ETag")
+	serverCmd.Flags().DurationVar(&server.MaxAge.Template, "max-age-template", 0, "Cache-Control max-age of rendered templates, 0 must revalidate the ETag")
+	serverCmd.Flags().DurationVar(&server.MaxAge.Image, "max-age-image", 0, "Cache-Control max-age of map images, 0 must revalidate the ETag")
+	serverCmd.Flags().String("cors-origins", "*", "comma separated origins allowed to call the api, like https://*.example.com, or * for any")
+	serverCmd.Flags().String("cors-methods", "GET, HEAD, OPTIONS", "comma separated methods allowed by cors preflights")
+	serverCmd.Flags().String("cors-headers", corsHeaders, "comma separated request headers allowed by cors preflights, or * for any")
+	serverCmd.Flags().Bool("cors-credentials", false, "allow cors requests with credentials, requires listed cors-origins instead of *")
+	serverCmd.Flags().Duration("cors-max-age", 10*time.Minute, "how long browsers may cache a cors preflight")
+	serverCmd.Flags().IntVar(&server.Compress.Level, "compress-level", 6, "brotli/gzip/deflate compression level 1-9 of the responses, 0 disables it")
+	serverCmd.Flags().IntVar(&server.Compress.MinSize, "compress-min-size", 1024, "compress responses of at least this many bytes")
+	serverCmd.Flags().StringVar(&server.Auth, "auth", "", "yaml file of client tokens, allowed providers, endpoints and quotas")
+	serverCmd.Flags().Float64Var(&server.Limit.Rate, "limit-rps", 0, "sustained requests per second for each client, 0 is unlimited")
+	serverCmd.Flags().IntVar(&server.Limit.Burst, "limit-burst", 10, "burst of requests for each client")
+	serverCmd.Flags().IntVar(&server.Limit.Daily, "limit-daily", 0, "requests per day for each client, 0 is unlimited")
+	serverCmd.Flags().BoolVar(&server.AllowKey, "allow-key", false, "allow requests to override the provider key with the key query parameter")
 
 	envCmd := &cobra.Command{
 		Use:   "env",
-		Short: "display env apikeys",
+		Short: "display the effective configuration, and where each value came from",
 		Run: func(cmd *cobra.Command, args []string) {
-			for _, p := range geo.Providers() {
-				fmt.Printf("GOGEO_%s = %s\n",
-					strings.ToUpper(p), geo.APIKey(p))
+			file := config.File
+
+			if len(file) == 0 {
+				file = defaultConfigFile()
+			}
+
+			fmt.Printf("%-18s = %s\n", "config", file)
+
+			for _, s := range config.settings {
+				fmt.Printf("%-18s = %s (%s)\n", s.Name, s.Redacted(), s.Source)
 			}
 		},
 	}
@@ -47,8 +118,17 @@ func main() {
 	rootCmd := &cobra.Command{
 		Use:  "gogeo",
 		Long: "Awesome geo fetching and backend service",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.apply(cmd, args)
+		},
 	}
-	rootCmd.PersistentFlags().BoolVarP(&config.Verbose, "verbose", "v", false, "")
+	rootCmd.PersistentFlags().BoolVarP(&config.Verbose, "verbose", "v", false, "log every provider request, same as --log-level debug")
+	rootCmd.PersistentFlags().StringVar(&config.File, "config", "", "config file, defaults to ~/.config/gogeo/config.yml")
+	rootCmd.PersistentFlags().String("log-level", "info", "log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().String("log-format", "text", "log format: text or json lines")
+	rootCmd.PersistentFlags().String("log-output", "stderr", "log to stderr, stdout or a file")
+	rootCmd.PersistentFlags().String("trace", "", "write tracing spans as json lines to a file, or - for stderr")
+	rootCmd.PersistentFlags().StringVar(&config.Tiles, "tiles", "", "tile directory or .mbtiles file used by the local provider, defaults to env: GOGEO_TILES")
 	rootCmd.AddCommand(serverCmd, envCmd)
 
 	for _, provider := range geo.Providers() {
@@ -78,6 +158,16 @@ func main() {
 		f.BoolVarP(&format.Json, "json", "j", false, "output json format")
 		f.BoolVarP(&format.Yaml, "yml", "y", false, "output yml format")
 		f.BoolVarP(&format.Xml, "xml", "x", false, "output xml format")
+		f.BoolVarP(&format.GeoJson, "geojson", "g", false, "output geojson format")
+		f.BoolVar(&format.Csv, "csv", false, "output csv format")
+		f.BoolVar(&format.Tsv, "tsv", false, "output tsv format")
+		f.BoolVar(&format.Kml, "kml", false, "output kml format")
+		f.BoolVar(&format.Gpx, "gpx", false, "output gpx format")
+		f.BoolVar(&format.NDJson, "ndjson", false, "output newline delimited json, streamed as results are ready")
+		f.StringVar(&format.Delimiter, "delimiter", "", "csv/tsv field delimiter")
+		f.BoolVar(&format.NoHeader, "no-header", false, "csv/tsv without the header row")
+		f.StringVar(&format.Template, "template", "", "go text/template file or inline template rendered for each result")
+		f.BoolVar(&format.TemplateAll, "template-all", false, "render the template once with the list of results")
 		f.BoolVarP(&format.Pretty, "pretty", "p", false, "pretty print")
 
 		imgCmd.Flags().StringVar(
@@ -86,14 +176,35 @@ func main() {
 			&image.Scale, "scale", 0, "usage")
 		imgCmd.Flags().Uint64Var(
 			&image.Zoom, "zoom", 0, "map zoom level, varies depending provider")
+		imgCmd.Flags().Var(
+			&image.Path, "path", "latitude,longitude point on a path")
+		imgCmd.Flags().StringVar(
+			&image.Format, "format", "", "image format png, jpg, gif or webp, defaults to the filename extension")
 
 		rootCmd.AddCommand(c)
 
-		serverCmd.Flags().String(provider+"-key", "", "optional depending on the specific provider")
+		serverCmd.Flags().String(provider+"-key", "", "api key used by the server, optional depending on the specific provider")
 
 		p.StringVar(&config.APIKey, "key", "", "optional depending on the specific provider")
+		p.BoolVarP(&force, "force", "f", false, "overwrite the output file if it exists")
+	}
+
+	// the config file is read before the flags are parsed, as it might
+	// hold the default provider.
+	file, err := loadConfig(configArg(os.Args[1:]))
+
+	if err != nil {
+		fmt.Println("config:", err)
+		os.Exit(1)
+	}
+
+	config.file = file
+	config.resolve(rootCmd)
+	rootCmd.SetArgs(withDefaultProvider(rootCmd, config.Get("default"), os.Args[1:]))
+
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
 	}
-	rootCmd.Execute()
 }
 
 func runImageProvider(cmd *cobra.Command, args []string) {
@@ -110,6 +221,17 @@ func runImageProvider(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	ctx, span := middleware.DefaultTracer.Start(context.Background(), "gogeo "+cmd.Parent().Use+" img", "internal")
+	defer func() { span.Finish(err) }()
+	provider = middleware.TraceProvider(ctx, cmd.Parent().Use, provider)
+
+	filename := args[0]
+
+	// an unknown extension is an error, rather than a png in disguise.
+	if len(image.Format) == 0 {
+		image.Format = filepath.Ext(filename)
+	}
+
 	opts, err := image.Map()
 
 	if err != nil {
@@ -123,11 +245,13 @@ func runImageProvider(cmd *cobra.Command, args []string) {
 		markers = append(markers, loc.String())
 	}
 
-	if b, err := provider.Image(markers, opts); err != nil {
-		fmt.Println(err)
-	} else {
+	var b []byte
 
-		ioutil.WriteFile(args[0]+".png", b, os.ModePerm)
+	// err is assigned, not redeclared, so the span records the failure.
+	if b, err = provider.Image(markers, opts); err != nil {
+		fmt.Println(err)
+	} else if err = writeOutput(filename, b, force); err != nil {
+		fmt.Println("output error:", err)
 	}
 }
 
@@ -145,32 +269,118 @@ func runProvider(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	for _, addr := range addrList {
-		if a, err := provider.Address(addr); err == nil {
-			v = append(v, a)
-		} else {
-			fmt.Println("skip:", addr, "error:", err.Error())
-		}
+	ctx, span := middleware.DefaultTracer.Start(context.Background(), "gogeo "+cmd.Use, "internal")
+	defer func() { span.Finish(err) }()
+	provider = middleware.TraceProvider(ctx, cmd.Use, provider)
+
+	if len(args) > 0 {
+		format.Output = args[0]
 	}
 
-	for _, loc := range locList {
-		if l, err := provider.Location(loc); err == nil {
-			v = append(v, l)
-		} else {
-			fmt.Println("skip:", loc, "error:", err.Error())
+	// the output is created before the lookups, so an existing file is
+	// refused before any request is made.
+	out, err := createOutput(format.Filename(), force)
+
+	if err != nil {
+		fmt.Println("output error:", err)
+		return
+	}
+
+	collect := func(r geo.Result) error {
+		v = append(v, r)
+		return nil
+	}
+
+	// stream encodings writes each result to the output, as soon as it's
+	// ready.
+	e, stream := encoders[format.String()].(StreamEncoding)
+	stream = stream && len(format.Template) == 0
+
+	if stream {
+		collect = e.NewEncoder(out).Encode
+	}
+
+	// failed lookups are written to the csv/tsv error column, and skipped
+	// by the other encodings.
+	keep := keepErrors(format.String()) && len(format.Template) == 0
+	fn := func(r geo.Result) error {
+		if len(r.Error) > 0 && !keep {
+			fmt.Fprintln(os.Stderr, "skip:", r.Query, "error:", r.Error)
+			return nil
 		}
+		return collect(r)
 	}
 
-	b, err := format.Marshal(&v)
+	// the cli looks up the addresses before the locations, unlike the http
+	// routes.
+	if err = lookup(provider, cmd.Use, addrList, nil, fn); err == nil {
+		err = lookup(provider, cmd.Use, nil, locList, fn)
+	}
 
 	if err != nil {
-		fmt.Println("marshal error:", err)
+		out.Abort()
+		fmt.Println("output error:", err)
 		return
 	}
 
-	if len(args) > 0 {
-		ioutil.WriteFile(format.Filename(args[0]), b, os.ModePerm)
-	} else {
-		fmt.Println(string(b))
+	if !stream {
+		var b []byte
+
+		if b, err = format.Marshal(&v); err != nil {
+			out.Abort()
+			fmt.Println("marshal error:", err)
+			return
+		}
+
+		if format.Stdout() {
+			b = append(b, '\n')
+		}
+
+		if _, err = out.Write(b); err != nil {
+			out.Abort()
+			fmt.Println("output error:", err)
+			return
+		}
+	}
+
+	if err = out.Close(); err != nil {
+		fmt.Println("output error:", err)
 	}
 }
+
+// lookup reverse geocodes the locations, and then geocodes the addresses,
+// fn is called with each result as soon as it's ready. Failed lookups are
+// passed on as results with an error, which only the csv/tsv encodings
+// keeps, and lookup stops if fn returns an error.
+func lookup(provider geo.Provider, name string, addrs []string, locs []geo.Location, fn func(geo.Result) error) error {
+	for _, loc := range locs {
+		r, err := provider.Location(loc)
+
+		if err != nil {
+			r = errorResult(name, loc.String(), err)
+		}
+
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	for _, addr := range addrs {
+		r, err := provider.Address(addr)
+
+		if err != nil {
+			r = errorResult(name, addr, err)
+		}
+
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// errorResult records a failed lookup, so it still shows up in the output.
+func errorResult(provider, qry string, err error) geo.Result {
+	return geo.Result{Query: qry, Provider: provider, Error: err.Error()}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+func TestRunProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gogeo")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	defer func() {
+		addrList, locList, format, force = nil, nil, formatFlags{}, false
+	}()
+
+	// ndjson is streamed to the output file, and the addresses are looked
+	// up before the locations.
+	name := filepath.Join(dir, "results.ndjson")
+	addrList = addressList{"55.1,12.1"}
+	locList = locationList{{Latitude: 56.2, Longitude: 10.2}}
+	format = formatFlags{NDJson: true}
+
+	runProvider(&cobra.Command{Use: "local"}, []string{name})
+
+	b, err := ioutil.ReadFile(name)
+	assert.Nil(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"query":"55.1,12.1"`)
+	assert.Contains(t, lines[1], `"query":"56.2,10.2"`)
+
+	// the existing file is kept without --force
+	locList = nil
+	runProvider(&cobra.Command{Use: "local"}, []string{name})
+
+	after, _ := ioutil.ReadFile(name)
+	assert.Equal(t, string(b), string(after))
+
+	force = true
+	runProvider(&cobra.Command{Use: "local"}, []string{name})
+
+	after, _ = ioutil.ReadFile(name)
+	assert.Equal(t, lines[0]+"\n", string(after))
+}
+
+func TestLookupOrder(t *testing.T) {
+	local, _ := geo.New("local")
+	var queries []string
+
+	lookup(local, "local", []string{"55.1,12.1"}, []geo.Location{{Latitude: 56.2, Longitude: 10.2}}, func(r geo.Result) error {
+		queries = append(queries, r.Query)
+		return nil
+	})
+
+	// the http routes reverse geocodes the locations first
+	assert.Equal(t, []string{"56.2,10.2", "55.1,12.1"}, queries)
+}
+
+func TestImageFormat(t *testing.T) {
+	// the format comes from the extension, when --format isn't given
+	opts, err := imageFlags{Size: "10x10", Format: ".jpeg"}.Map()
+	assert.Nil(t, err)
+	assert.Equal(t, "jpg", opts.Format)
+
+	opts, err = imageFlags{Size: "10x10"}.Map()
+	assert.Nil(t, err)
+	assert.Equal(t, "png", opts.Format)
+
+	_, err = imageFlags{Size: "10x10", Format: ".bmp"}.Map()
+	assert.EqualError(t, err, "unsupported image format: 'bmp'")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/harboe/gogeo/geo"
+	"github.com/harboe/gogeo/geo/middleware"
+)
+
+var (
+	httpRequests = middleware.DefaultRegistry.Counter("gogeo_http_requests_total",
+		"Requests to the rest service, by route and status.",
+		"route", "status")
+	httpDuration = middleware.DefaultRegistry.Histogram("gogeo_http_request_duration_seconds",
+		"Latency of the requests to the rest service, by route and status.",
+		middleware.DefaultBuckets, "route", "status")
+)
+
+// statusWriter records the status and the size of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Status is the response status, 200 if nothing was written.
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Flush keeps streaming responses working.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// metricsHandler is the alice middleware, which counts the requests and
+// their latency by route and status.
+func metricsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, req)
+
+		route, status := routeName(req.URL.Path), strconv.Itoa(sw.Status())
+		httpRequests.Add(1, route, status)
+		httpDuration.Observe(time.Since(start).Seconds(), route, status)
+	})
+}
+
+// routeName is the route pattern of path, so the metrics doesn't get a
+// label for every provider name or template. Unknown routes are "other".
+func routeName(path string) string {
+	switch path {
+	case "/results.xsd", "/stats", "/metrics", "/healthz", "/readyz", "/providers", "/openapi.json", "/docs":
+		return path
+	}
+
+	_, endpoint := routeOf(path)
+
+	if endpoint == "template" {
+		return "/:name/template/:template"
+	}
+
+	if endpoint == "geocode" || endpoint == "reverse" {
+		return "/:name/" + endpoint
+	}
+
+	if _, ok := encoders[endpoint]; ok {
+		return "/:name/" + endpoint
+	}
+
+	if _, err := geo.NewFormat(endpoint); err == nil && len(endpoint) > 0 {
+		return "/:name/" + endpoint
+	}
+
+	return "other"
+}
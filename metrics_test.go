@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harboe/gogeo/geo/middleware"
+)
+
+func TestRouteName(t *testing.T) {
+	assert.Equal(t, "/:name/json", routeName("/google/json"))
+	assert.Equal(t, "/:name/jpeg", routeName("/google/jpeg"))
+	assert.Equal(t, "/:name/template/:template", routeName("/google/template/sites.csv"))
+	assert.Equal(t, "/:name/reverse", routeName("/google/reverse"))
+	assert.Equal(t, "/results.xsd", routeName("/results.xsd"))
+	assert.Equal(t, "other", routeName("/google/unknown"))
+	assert.Equal(t, "other", routeName("/"))
+}
+
+func TestMetrics(t *testing.T) {
+	h := metricsHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeError(w, http.StatusTeapot, "teapot")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/google/json", nil))
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("0123456789"))
+	}))
+	defer backend.Close()
+
+	f := middleware.Metrics(middleware.Cache(http.DefaultClient, time.Minute, 10), "local")
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", backend.URL, nil)
+		resp, err := f.Do(req)
+		assert.Nil(t, err)
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	var buf bytes.Buffer
+	middleware.DefaultRegistry.WriteTo(&buf)
+	out := buf.String()
+
+	for _, line := range []string{
+		`gogeo_http_requests_total{route="/:name/json",status="418"} 1`,
+		`gogeo_http_request_duration_seconds_count{route="/:name/json",status="418"} 1`,
+		`gogeo_provider_requests_total{provider="local",result="ok"} 2`,
+		`gogeo_provider_cache_requests_total{provider="local",cache="hit"} 1`,
+		`gogeo_provider_cache_hit_ratio{provider="local"} 0.5`,
+		`gogeo_provider_response_bytes_total{provider="local"} 20`,
+		`# TYPE gogeo_provider_request_duration_seconds histogram`,
+	} {
+		assert.True(t, strings.Contains(out, line+"\n"), line)
+	}
+}
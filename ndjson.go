@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+type (
+	// ndjsonEncoding writes newline delimited json, one result per line.
+	ndjsonEncoding struct{}
+	ndjsonEncoder  struct {
+		enc *json.Encoder
+	}
+)
+
+// Marshal encodes all results at once, pretty is ignored as every result
+// must be on a single line.
+func (e ndjsonEncoding) Marshal(v interface{}, pretty bool) ([]byte, error) {
+	results, err := toResults(v)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	var buf bytes.Buffer
+	enc := e.NewEncoder(&buf)
+
+	for _, r := range results {
+		if err := enc.Encode(r); err != nil {
+			return []byte{}, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (e ndjsonEncoding) NewEncoder(w io.Writer) StreamEncoder {
+	return &ndjsonEncoder{enc: json.NewEncoder(w)}
+}
+
+func (e *ndjsonEncoder) Encode(r geo.Result) error {
+	return e.enc.Encode(&r)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+func TestNDJSONEncoding(t *testing.T) {
+	results := []geo.Result{
+		{Query: "55.676,12.568", Location: geo.Location{Latitude: 55.676, Longitude: 12.568}, Provider: "local"},
+		{Query: "valby", Provider: "local", Error: "address lookup not supported offline: valby"},
+	}
+	expected := `{"query":"55.676,12.568","address":"","country":"","location":{"lat":55.676,"lng":12.568},"provider":"local"}` + "\n" +
+		`{"query":"valby","address":"","country":"","location":{"lat":0,"lng":0},"provider":"local","error":"address lookup not supported offline: valby"}` + "\n"
+
+	// pretty is ignored, every result is a single line
+	b, err := Marshal("ndjson", &results, true)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(b))
+
+	// the stream encoder writes each result as it's encoded
+	var buf bytes.Buffer
+	enc := ndjsonEncoding{}.NewEncoder(&buf)
+
+	assert.Nil(t, enc.Encode(results[0]))
+	assert.Equal(t, expected[:bytes.IndexByte([]byte(expected), '\n')+1], buf.String())
+	assert.Nil(t, enc.Encode(results[1]))
+	assert.Equal(t, expected, buf.String())
+}
+
+// slowProvider takes delay to look up each location.
+type slowProvider struct {
+	geo.Provider
+	delay time.Duration
+}
+
+func (p slowProvider) Location(loc geo.Location) (geo.Result, error) {
+	time.Sleep(p.delay)
+	return p.Provider.Location(loc)
+}
+
+func TestNDJSONStreamWriteTimeout(t *testing.T) {
+	server.WriteTimeout = 150 * time.Millisecond
+	server.Compress = compression{Level: 6, MinSize: 1024}
+	defer func() { server.WriteTimeout, server.Compress = 0, compression{} }()
+
+	h, err := restHandler()
+	assert.Nil(t, err)
+
+	srv := httptest.NewUnstartedServer(h)
+	srv.Config.WriteTimeout = server.WriteTimeout
+	srv.Start()
+	defer srv.Close()
+
+	local, _ := geo.New("local")
+	providers["local"] = slowProvider{Provider: local, delay: 60 * time.Millisecond}
+
+	// the stream takes twice the write timeout, but each chunk is within it,
+	// with and without compression.
+	for _, encoding := range []string{"identity", "gzip"} {
+		req, _ := http.NewRequest("GET", srv.URL+"/local/ndjson?loc=1,1&loc=2,2&loc=3,3&loc=4,4&loc=5,5", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		assert.Nil(t, err)
+
+		body := io.Reader(resp.Body)
+
+		if encoding == "gzip" {
+			assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+			body, err = gzip.NewReader(resp.Body)
+			assert.Nil(t, err)
+		}
+
+		b, err := ioutil.ReadAll(body)
+		resp.Body.Close()
+		assert.Nil(t, err, encoding)
+
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		assert.Len(t, lines, 5, encoding)
+		assert.Contains(t, lines[len(lines)-1], `"query":"5,5"`, encoding)
+	}
+}
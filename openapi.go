@@ -0,0 +1,277 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+// obj is a json object of the openapi spec
+type obj = map[string]interface{}
+
+// docsHTML renders /openapi.json, and lets you try the routes
+//
+//go:embed docs.html
+var docsHTML []byte
+
+// parameters of the routes, by name
+var parameters = obj{
+	"name":      param("path", "name", "provider: "+strings.Join(geo.Providers(), ", "), obj{"type": "string", "enum": geo.Providers()}),
+	"template":  param("path", "template", "template name, loaded from --templates", obj{"type": "string"}),
+	"addr":      param("query", "addr", "street address to geocode, repeat for more addresses", obj{"type": "array", "items": obj{"type": "string"}}),
+	"loc":       param("query", "loc", "latitude,longitude to reverse geocode, repeat for more locations", obj{"type": "array", "items": obj{"type": "string", "pattern": `^-?[0-9.]+,-?[0-9.]+$`}}),
+	"key":       param("query", "key", "overrides the provider api key, if the server runs with --allow-key", obj{"type": "string"}),
+	"pretty":    flagParam("pretty", "pretty print"),
+	"delimiter": param("query", "delimiter", "csv/tsv field delimiter", obj{"type": "string", "maxLength": 1}),
+	"header":    param("query", "header", "header=false omits the csv/tsv header row", obj{"type": "boolean", "default": true}),
+	"all":       flagParam("all", "render the template once with the list of results"),
+	"size":      param("query", "size", "map size {width}x{height}, max 640x640", obj{"type": "string", "pattern": `^[0-9]+x[0-9]+$`, "default": "250x250"}),
+	"zoom":      param("query", "zoom", "map zoom level, fits the markers when omitted", obj{"type": "integer", "minimum": 0, "maximum": geo.MaxZoom}),
+	"scale":     param("query", "scale", "map scale, eg. 2 for high dpi", obj{"type": "integer", "minimum": 0, "maximum": geo.MaxScale}),
+	"path":      param("query", "path", "latitude,longitude point on a path drawn on the map, repeat for more points", obj{"type": "array", "items": obj{"type": "string"}}),
+	"format":    param("query", "format", "output format", obj{"type": "string", "enum": []string{"json", "yml", "xml"}, "default": "json"}),
+}
+
+func param(in, name, description string, schema obj) obj {
+	p := obj{"name": name, "in": in, "description": description, "schema": schema}
+
+	if in == "path" {
+		p["required"] = true
+	}
+
+	if t, _ := schema["type"].(string); t == "array" {
+		p["style"], p["explode"] = "form", true
+	}
+
+	return p
+}
+
+// flagParam is a boolean query parameter, which is true if it's given, even
+// without a value, like ?pretty.
+func flagParam(name, description string) obj {
+	p := param("query", name, description, obj{"type": "boolean"})
+	p["allowEmptyValue"] = true
+	return p
+}
+
+func refs(names ...string) []obj {
+	var l []obj
+
+	for _, n := range names {
+		l = append(l, obj{"$ref": "#/components/parameters/" + n})
+	}
+
+	return l
+}
+
+// responses returns the 200 response of the content types, and the error
+// responses. The routes with errors are the lookups, which also has ETags.
+func responses(description string, content obj, errors ...int) obj {
+	r := obj{"200": obj{"description": description, "content": content}}
+
+	if len(errors) > 0 {
+		r["304"] = obj{"description": "not modified, the If-None-Match header matches the ETag"}
+	}
+
+	for _, status := range errors {
+		r[strconv.Itoa(status)] = obj{"$ref": "#/components/responses/Error"}
+	}
+
+	return r
+}
+
+func schemaRef(name string) obj {
+	return obj{"$ref": "#/components/schemas/" + name}
+}
+
+// openAPISpec describes every route of the rest service, as an openapi 3
+// document.
+func openAPISpec() obj {
+	paths := obj{}
+	providerErrors := []int{401, 403, 404, 429, 500}
+
+	for _, f := range geoFormats {
+		schema := obj{"type": "string"}
+
+		switch f {
+		case "json", "yml":
+			schema = obj{"type": "array", "items": schemaRef("Result")}
+		case "ndjson":
+			schema = schemaRef("Result")
+		case "geojson":
+			schema = obj{"type": "object", "description": "FeatureCollection of the results"}
+		case "xml":
+			schema = obj{"type": "string", "description": "results document, described by /results.xsd"}
+		}
+
+		params := refs("name", "addr", "loc", "key", "pretty")
+
+		if f == "csv" || f == "tsv" {
+			params = append(params, refs("delimiter", "header")...)
+		}
+
+		paths["/{name}/"+f] = obj{"get": obj{
+			"summary":     "geocode addresses and reverse geocode locations as " + f,
+			"description": "failed lookups are written to the error column of csv/tsv, and skipped by the other encodings.",
+			"tags":        []string{"geocoding"},
+			"operationId": "geocode_" + f,
+			"parameters":  params,
+			"responses":   responses("results", obj{ContentType(f): obj{"schema": schema}}, providerErrors...),
+		}}
+	}
+
+	negotiated := obj{}
+	for _, m := range mediaTypes {
+		if _, ok := negotiated[ContentType(m.Encoder)]; !ok {
+			negotiated[ContentType(m.Encoder)] = obj{"schema": obj{"description": m.Encoder + " encoding of the results"}}
+		}
+	}
+
+	for endpoint, param := range map[string]string{"geocode": "addr", "reverse": "loc"} {
+		// the parameter is required by these routes
+		required := obj{"required": true}
+		for k, v := range parameters[param].(obj) {
+			required[k] = v
+		}
+
+		params := append(refs("name"), required)
+		params = append(params, refs("key", "pretty", "delimiter", "header")...)
+
+		paths["/{name}/"+endpoint] = obj{"get": obj{
+			"summary":     endpoint + " the " + param + " parameters, in the encoding of the Accept header",
+			"description": "the encoding is chosen by the Accept header, json if it's missing. failed lookups are written to the error column of csv/tsv, and skipped by the other encodings.",
+			"tags":        []string{"geocoding"},
+			"operationId": endpoint,
+			"parameters":  params,
+			"responses":   responses("results", negotiated, append([]int{400, 406}, providerErrors...)...),
+		}}
+	}
+
+	paths["/{name}/template/{template}"] = obj{"get": obj{
+		"summary":     "render the results through a template",
+		"tags":        []string{"geocoding"},
+		"operationId": "geocode_template",
+		"parameters":  refs("name", "template", "addr", "loc", "key", "all"),
+		"responses":   responses("rendered template", obj{"*/*": obj{"schema": obj{"type": "string"}}}, providerErrors...),
+	}}
+
+	for _, f := range append([]string{"jpeg"}, geo.Formats()...) {
+		format, _ := geo.NewFormat(f)
+		paths["/{name}/"+f] = obj{"get": obj{
+			"summary":     "static map with markers of the addresses and locations, as " + f,
+			"tags":        []string{"maps"},
+			"operationId": "image_" + f,
+			"parameters":  refs("name", "addr", "loc", "size", "zoom", "scale", "path", "key"),
+			"responses":   responses("map image", obj{geo.ContentType(format): obj{"schema": obj{"type": "string", "format": "binary"}}}, append(providerErrors, 502)...),
+		}}
+	}
+
+	text := func(summary, tag, contentType string, schema obj, params ...string) obj {
+		op := obj{
+			"summary":     summary,
+			"tags":        []string{tag},
+			"operationId": strings.NewReplacer(" ", "_", ",", "").Replace(summary),
+			"responses":   responses(summary, obj{contentType: obj{"schema": schema}}),
+		}
+
+		if len(params) > 0 {
+			op["parameters"] = refs(params...)
+		}
+
+		return obj{"get": op}
+	}
+
+	paths["/results.xsd"] = text("xml schema of the results", "schemas", "application/xml", obj{"type": "string"})
+	paths["/openapi.json"] = text("openapi spec", "schemas", "application/json", obj{"type": "object"})
+	paths["/docs"] = text("api documentation", "schemas", "text/html", obj{"type": "string"})
+	paths["/metrics"] = text("prometheus metrics", "status", "text/plain", obj{"type": "string"})
+	paths["/healthz"] = text("process is up", "status", "application/json", obj{"type": "object"})
+	paths["/readyz"] = text("ready to serve requests", "status", "application/json", obj{"type": "object"})
+	paths["/providers"] = text("provider status", "status", "application/json", obj{"type": "object"}, "format", "pretty")
+	paths["/stats"] = text("rate limit counters, when rate limits are enabled", "status", "application/json", obj{"type": "object"})
+	paths["/readyz"].(obj)["get"].(obj)["responses"].(obj)["503"] = obj{"description": "no provider is ready"}
+
+	location := obj{"type": "object", "properties": obj{"lat": obj{"type": "number"}, "lng": obj{"type": "number"}}}
+
+	return obj{
+		"openapi": "3.0.3",
+		"info": obj{
+			"title":       "gogeo",
+			"description": "geocoding, reverse geocoding and static maps, for various providers in a common format.",
+			"version":     "1",
+		},
+		"servers":  []obj{{"url": "/"}},
+		"security": []obj{{}, {"bearer": []string{}}, {"apiKey": []string{}}},
+		"paths":    paths,
+		"components": obj{
+			"parameters": parameters,
+			"securitySchemes": obj{
+				"bearer": obj{"type": "http", "scheme": "bearer", "description": "client token, if the server runs with --auth"},
+				"apiKey": obj{"type": "apiKey", "in": "header", "name": "X-API-Key", "description": "client token, if the server runs with --auth"},
+			},
+			"responses": obj{
+				"Error": obj{
+					"description": "error envelope",
+					"content":     obj{"application/json": obj{"schema": schemaRef("Error")}},
+				},
+			},
+			"schemas": obj{
+				"Location": location,
+				"Bounds": obj{
+					"type":       "object",
+					"properties": obj{"sw": schemaRef("Location"), "ne": schemaRef("Location")},
+				},
+				"Result": obj{
+					"type":     "object",
+					"required": []string{"query", "address", "country", "location"},
+					"properties": obj{
+						"query":    obj{"type": "string"},
+						"address":  obj{"type": "string"},
+						"street":   obj{"type": "string"},
+						"country":  obj{"type": "string"},
+						"city":     obj{"type": "string"},
+						"zip":      obj{"type": "string"},
+						"state":    obj{"type": "string"},
+						"location": schemaRef("Location"),
+						"bounds":   schemaRef("Bounds"),
+						"provider": obj{"type": "string"},
+						"error":    obj{"type": "string", "description": "set if the lookup failed, only csv/tsv has failed lookups"},
+					},
+				},
+				"Error": obj{
+					"type": "object",
+					"properties": obj{
+						"error": obj{
+							"type": "object",
+							"properties": obj{
+								"status":  obj{"type": "integer"},
+								"message": obj{"type": "string"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func openAPIHandler(w http.ResponseWriter, req *http.Request) {
+	_, pretty := req.URL.Query()["pretty"]
+	b, err := Marshal("json", openAPISpec(), pretty)
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(b)
+}
+
+func docsHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsHTML)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	gotoken "go/token"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	// enables the /stats route
+	server.Limit = limiter{Rate: 1, Burst: 1}
+	defer func() { server.Limit = limiter{} }()
+
+	h, err := restHandler()
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			Parameters []obj `json:"parameters"`
+		} `json:"paths"`
+	}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &spec))
+	assert.Equal(t, "3.0.3", spec.OpenAPI)
+
+	wildcard := regexp.MustCompile(`:(\w+)`)
+	var expected, actual []string
+
+	for _, r := range routes {
+		expected = append(expected, wildcard.ReplaceAllString(r.path, "{$1}"))
+	}
+
+	for p, ops := range spec.Paths {
+		actual = append(actual, p)
+		assert.Contains(t, ops, "get", p)
+	}
+
+	sort.Strings(expected)
+	sort.Strings(actual)
+	assert.Equal(t, expected, actual)
+
+	// every parameter reference resolves
+	b, _ := json.Marshal(openAPISpec())
+	for _, ref := range regexp.MustCompile(`"#/components/parameters/(\w+)"`).FindAllStringSubmatch(string(b), -1) {
+		assert.Contains(t, parameters, ref[1])
+	}
+
+	// every documented query parameter is read by the handler of the route
+	reads := queryReads(t)
+
+	for _, r := range routes {
+		path := wildcard.ReplaceAllString(r.path, "{$1}")
+		name := handlerName(r.handler)
+
+		for _, p := range spec.Paths[path]["get"].Parameters {
+			if ref, ok := p["$ref"].(string); ok {
+				p = parameters[strings.TrimPrefix(ref, "#/components/parameters/")].(obj)
+			}
+
+			if p["in"] == "query" {
+				assert.True(t, reads(name)[p["name"].(string)], "%s: %s doesn't read %s", path, name, p["name"])
+			}
+		}
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/docs", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "openapi.json")
+}
+
+func TestOpenAPIFlags(t *testing.T) {
+	// allowEmptyValue belongs to the parameter, not its schema
+	for _, name := range []string{"pretty", "all"} {
+		p := parameters[name].(obj)
+		assert.Equal(t, true, p["allowEmptyValue"], name)
+		assert.Equal(t, obj{"type": "boolean"}, p["schema"], name)
+	}
+
+	b, _ := json.Marshal(openAPISpec())
+	assert.NotContains(t, string(b), `"schema":{"allowEmptyValue"`)
+}
+
+// handlerName returns the function name of a route handler, or "" if it
+// isn't a function of this package.
+func handlerName(h interface{}) string {
+	v := reflect.ValueOf(h)
+
+	if v.Kind() != reflect.Func {
+		return ""
+	}
+
+	name := runtime.FuncForPC(v.Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// queryReads parses the package, and returns a func of the query
+// parameters a function reads, itself or through the functions it calls.
+func queryReads(t *testing.T) func(string) map[string]bool {
+	fset := gotoken.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	assert.Nil(t, err)
+
+	// the query is qry, or req.URL.Query()
+	isQuery := func(e ast.Expr) bool {
+		switch x := e.(type) {
+		case *ast.Ident:
+			return x.Name == "qry"
+		case *ast.CallExpr:
+			sel, ok := x.Fun.(*ast.SelectorExpr)
+			return ok && sel.Sel.Name == "Query"
+		}
+		return false
+	}
+	literal := func(e ast.Expr) (string, bool) {
+		if lit, ok := e.(*ast.BasicLit); ok && lit.Kind == gotoken.STRING {
+			s, err := strconv.Unquote(lit.Value)
+			return s, err == nil
+		}
+		return "", false
+	}
+
+	keys := map[string]map[string]bool{}
+	calls := map[string][]string{}
+
+	for _, f := range pkgs["main"].Files {
+		for _, d := range f.Decls {
+			fn, ok := d.(*ast.FuncDecl)
+
+			if !ok || fn.Recv != nil || fn.Body == nil {
+				continue
+			}
+
+			name := fn.Name.Name
+			keys[name] = map[string]bool{}
+
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				switch x := n.(type) {
+				case *ast.IndexExpr:
+					if k, ok := literal(x.Index); ok && isQuery(x.X) {
+						keys[name][k] = true
+					}
+				case *ast.CallExpr:
+					switch fun := x.Fun.(type) {
+					case *ast.Ident:
+						calls[name] = append(calls[name], fun.Name)
+					case *ast.SelectorExpr:
+						if len(x.Args) == 1 && fun.Sel.Name == "Get" && isQuery(fun.X) {
+							if k, ok := literal(x.Args[0]); ok {
+								keys[name][k] = true
+							}
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+
+	return func(name string) map[string]bool {
+		reads := map[string]bool{}
+		seen := map[string]bool{}
+
+		var visit func(string)
+		visit = func(fn string) {
+			if seen[fn] {
+				return
+			}
+			seen[fn] = true
+
+			for k := range keys[fn] {
+				reads[k] = true
+			}
+
+			for _, c := range calls[fn] {
+				visit(c)
+			}
+		}
+
+		visit(name)
+		return reads
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// output is an output file being written. It's written to a temporary
+// file next to it, which Close renames into place, so readers never see
+// a partial file.
+type output struct {
+	*os.File
+	name string
+}
+
+// createOutput creates the output file name, or stdout if name is "-".
+// Existing files are only overwritten with force.
+func createOutput(name string, force bool) (*output, error) {
+	if name == "-" {
+		return &output{File: os.Stdout, name: name}, nil
+	}
+
+	if _, err := os.Stat(name); err == nil && !force {
+		return nil, fmt.Errorf("%s already exists, use --force to overwrite", name)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &output{File: tmp, name: name}, nil
+}
+
+// Close renames the temporary file to the output file, once it's on disk.
+func (o *output) Close() error {
+	if o.name == "-" {
+		return nil
+	}
+
+	// only leaves the temporary file behind if the rename never happened.
+	defer os.Remove(o.File.Name())
+
+	if err := o.File.Sync(); err != nil {
+		o.File.Close()
+		return err
+	}
+
+	if err := o.File.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(o.File.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(o.File.Name(), o.name)
+}
+
+// Abort removes the temporary file, and leaves the output file as it was.
+func (o *output) Abort() {
+	if o.name == "-" {
+		return
+	}
+
+	o.File.Close()
+	os.Remove(o.File.Name())
+}
+
+// writeOutput writes b to the file name, or stdout if name is "-".
+func writeOutput(name string, b []byte, force bool) error {
+	o, err := createOutput(name, force)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := o.Write(b); err != nil {
+		o.Abort()
+		return err
+	}
+
+	return o.Close()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// files lists the names in dir, including the hidden temporary files.
+func files(dir string) []string {
+	var names []string
+	infos, _ := ioutil.ReadDir(dir)
+
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+	}
+
+	return names
+}
+
+func TestWriteOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gogeo")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "results.json")
+
+	assert.Nil(t, writeOutput(name, []byte("[1]"), false))
+	b, _ := ioutil.ReadFile(name)
+	assert.Equal(t, "[1]", string(b))
+	assert.Equal(t, []string{"results.json"}, files(dir))
+
+	fi, _ := os.Stat(name)
+	assert.Equal(t, os.FileMode(0644), fi.Mode().Perm())
+
+	// an existing file is only overwritten with force
+	err = writeOutput(name, []byte("[2]"), false)
+	assert.EqualError(t, err, name+" already exists, use --force to overwrite")
+	b, _ = ioutil.ReadFile(name)
+	assert.Equal(t, "[1]", string(b))
+
+	assert.Nil(t, writeOutput(name, []byte("[2]"), true))
+	b, _ = ioutil.ReadFile(name)
+	assert.Equal(t, "[2]", string(b))
+
+	// the temporary file is removed, if the rename fails
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "sub", "x"), nil, 0644))
+	assert.NotNil(t, writeOutput(filepath.Join(dir, "sub"), []byte("[3]"), true))
+	assert.Equal(t, []string{"results.json", "sub"}, files(dir))
+
+	assert.NotNil(t, writeOutput(filepath.Join(dir, "missing", "results.json"), []byte("[3]"), true))
+}
+
+func TestOutputAbort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gogeo")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "results.ndjson")
+	assert.Nil(t, ioutil.WriteFile(name, []byte("old\n"), 0644))
+
+	// the output isn't visible until it's closed
+	out, err := createOutput(name, true)
+	assert.Nil(t, err)
+	out.Write([]byte("new\n"))
+
+	b, _ := ioutil.ReadFile(name)
+	assert.Equal(t, "old\n", string(b))
+	assert.Len(t, files(dir), 2)
+
+	// and an aborted output leaves the file as it was
+	out.Abort()
+	b, _ = ioutil.ReadFile(name)
+	assert.Equal(t, "old\n", string(b))
+	assert.Equal(t, []string{"results.ndjson"}, files(dir))
+
+	out, err = createOutput(name, true)
+	assert.Nil(t, err)
+	out.Write([]byte("new\n"))
+	assert.Nil(t, out.Close())
+
+	b, _ = ioutil.ReadFile(name)
+	assert.Equal(t, "new\n", string(b))
+	assert.Equal(t, []string{"results.ndjson"}, files(dir))
+}
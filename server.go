@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/harboe/gogeo/geo/middleware"
+)
+
+// certReloader serves the tls certificate, and reloads it from the cert
+// and key files on SIGHUP, so renewed certificates doesn't need a restart.
+type certReloader struct {
+	sync.RWMutex
+	certFile, keyFile string
+	cert              *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	return r, r.reload()
+}
+
+// reload keeps the current certificate, if the files can't be loaded.
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+
+	if err != nil {
+		return fmt.Errorf("tls: %v", err)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.cert = &cert
+	return nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.RLock()
+	defer r.RUnlock()
+	return r.cert, nil
+}
+
+// listen listens on the unix socket if --socket is given, otherwise on the
+// tcp address. A socket file left behind by a previous server is removed,
+// but not the socket of a server, which is still running.
+func listen(addr string) (net.Listener, string, error) {
+	if len(server.Socket) == 0 {
+		ln, err := net.Listen("tcp", addr)
+		return ln, addr, err
+	}
+
+	if fi, err := os.Stat(server.Socket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", server.Socket); err == nil {
+			conn.Close()
+			return nil, "", fmt.Errorf("socket: %s is in use by another server", server.Socket)
+		}
+
+		os.Remove(server.Socket)
+	}
+
+	ln, err := net.Listen("unix", server.Socket)
+	return ln, "unix:" + server.Socket, err
+}
+
+// serve serves h until SIGINT or SIGTERM, then it stops accepting
+// connections and waits up to the shutdown timeout for the requests in
+// flight to finish. SIGHUP reloads the tls certificate.
+func serve(addr string, h http.Handler) error {
+	srv := &http.Server{
+		Handler:           h,
+		ReadTimeout:       server.ReadTimeout,
+		ReadHeaderTimeout: server.ReadTimeout,
+		WriteTimeout:      server.WriteTimeout,
+		IdleTimeout:       server.IdleTimeout,
+	}
+
+	ln, where, err := listen(addr)
+
+	if err != nil {
+		return err
+	}
+
+	scheme := "http"
+	var certs *certReloader
+
+	if len(server.TLSCert) > 0 || len(server.TLSKey) > 0 {
+		if certs, err = newCertReloader(server.TLSCert, server.TLSKey); err != nil {
+			ln.Close()
+			return err
+		}
+
+		scheme = "https"
+		srv.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	done := make(chan error, 1)
+
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				if certs == nil {
+					continue
+				}
+
+				if err := certs.reload(); err != nil {
+					middleware.DefaultLog.Print(middleware.LevelError, "reload", middleware.Fields{"error": err.Error()})
+				} else {
+					middleware.DefaultLog.Print(middleware.LevelInfo, "reload", middleware.Fields{"cert": server.TLSCert})
+				}
+				continue
+			}
+
+			middleware.DefaultLog.Print(middleware.LevelInfo, "shutdown", middleware.Fields{
+				"signal":  sig.String(),
+				"timeout": server.ShutdownTimeout.String(),
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), server.ShutdownTimeout)
+			err := srv.Shutdown(ctx)
+			cancel()
+
+			if err != nil {
+				srv.Close()
+			}
+
+			done <- err
+			return
+		}
+	}()
+
+	if strings.HasPrefix(where, "unix:") {
+		fmt.Printf("rest service ready at %s (%s)\n", where, scheme)
+	} else {
+		fmt.Printf("rest service ready at %s://%s\n", scheme, where)
+	}
+
+	// ServeTLS, unlike Serve with a tls listener, negotiates http/2. The
+	// certificate comes from GetCertificate, so no files are given.
+	if certs != nil {
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		err = srv.Serve(ln)
+	}
+
+	if err != http.ErrServerClosed {
+		return err
+	}
+
+	if err := <-done; err != nil {
+		return fmt.Errorf("shutdown: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeDrainsOnShutdown(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gogeo")
+	defer os.RemoveAll(dir)
+
+	server.Socket = filepath.Join(dir, "gogeo.sock")
+	server.ShutdownTimeout = 5 * time.Second
+	defer func() { server.Socket = "" }()
+
+	started := make(chan bool)
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	served := make(chan error)
+	go func() { served <- serve("", h) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", server.Socket)
+		},
+	}}
+
+	waitForSocket()
+
+	type result struct {
+		body string
+		err  error
+	}
+	response := make(chan result)
+
+	go func() {
+		resp, err := client.Get("http://gogeo/slow")
+		if err != nil {
+			response <- result{err: err}
+			return
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		response <- result{body: string(b)}
+	}()
+
+	<-started
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	r := <-response
+	assert.Nil(t, r.err)
+	assert.Equal(t, "done", r.body)
+	assert.Nil(t, <-served)
+
+	_, err := os.Stat(server.Socket)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// waitForSocket waits for serve to create the socket.
+func waitForSocket() {
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(server.Socket); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestListenSocket(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gogeo")
+	defer os.RemoveAll(dir)
+
+	server.Socket = filepath.Join(dir, "gogeo.sock")
+	defer func() { server.Socket = "" }()
+
+	// the socket of a running server is left alone
+	running, err := net.Listen("unix", server.Socket)
+	assert.Nil(t, err)
+	running.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	_, _, err = listen("")
+	assert.EqualError(t, err, "socket: "+server.Socket+" is in use by another server")
+
+	// once it's stopped, the socket it left behind is replaced
+	running.Close()
+	_, err = os.Stat(server.Socket)
+	assert.Nil(t, err)
+
+	ln, where, err := listen("")
+	assert.Nil(t, err)
+	assert.Equal(t, "unix:"+server.Socket, where)
+	ln.Close()
+
+	// other files are never removed
+	ioutil.WriteFile(server.Socket, []byte("data"), 0644)
+	_, _, err = listen("")
+	assert.NotNil(t, err)
+	b, _ := ioutil.ReadFile(server.Socket)
+	assert.Equal(t, "data", string(b))
+}
+
+func TestServeTLS(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gogeo")
+	defer os.RemoveAll(dir)
+
+	// a self signed certificate
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gogeo"},
+		DNSNames:     []string{"gogeo"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "gogeo"}}, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	server.TLSCert = filepath.Join(dir, "cert.pem")
+	server.TLSKey = filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(server.TLSCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(server.TLSKey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	server.Socket = filepath.Join(dir, "gogeo.sock")
+	server.ShutdownTimeout = 5 * time.Second
+	defer func() { server.Socket, server.TLSCert, server.TLSKey = "", "", "" }()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Proto))
+	})
+
+	served := make(chan error)
+	go func() { served <- serve("", h) }()
+	waitForSocket()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", server.Socket)
+		},
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+
+	resp, err := client.Get("https://gogeo/")
+	assert.Nil(t, err)
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// the certificate from the files, over http/2
+	assert.Equal(t, "HTTP/2.0", string(b))
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, "gogeo", resp.TLS.PeerCertificates[0].Subject.CommonName)
+
+	client.CloseIdleConnections()
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	assert.Nil(t, <-served)
+}
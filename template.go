@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+type (
+	// templateEncoding renders results through a user defined template.
+	// Each result is rendered on it's own line, unless All is set where the
+	// template is rendered once with the whole list.
+	templateEncoding struct {
+		Template executor
+		All      bool
+	}
+	// executor is implemented by both text and html templates
+	executor interface {
+		Execute(w io.Writer, data interface{}) error
+	}
+)
+
+func (e templateEncoding) Marshal(v interface{}, pretty bool) ([]byte, error) {
+	results, err := toResults(v)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	var buf bytes.Buffer
+
+	if e.All {
+		err = e.Template.Execute(&buf, results)
+		return buf.Bytes(), err
+	}
+
+	for _, r := range results {
+		if err := e.Template.Execute(&buf, r); err != nil {
+			return []byte{}, err
+		}
+
+		if b := buf.Bytes(); len(b) > 0 && b[len(b)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// parseTemplate parses the template file, or the template itself if
+// there is no such file.
+func parseTemplate(tmpl string) (*template.Template, error) {
+	if fi, err := os.Stat(tmpl); err == nil && !fi.IsDir() {
+		return template.ParseFiles(tmpl)
+	}
+
+	return template.New("template").Parse(tmpl)
+}
+
+// loadTemplates parses every file in dir, the template name is the
+// filename without the .tmpl extension, eg. sites.csv.tmpl is "sites.csv".
+// Html templates are parsed with html/template, so queries are escaped.
+func loadTemplates(dir string) (map[string]executor, error) {
+	templates := map[string]executor{}
+	files, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		return templates, err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+
+		name := strings.TrimSuffix(f.Name(), ".tmpl")
+		path := filepath.Join(dir, f.Name())
+		var t executor
+
+		switch filepath.Ext(name) {
+		case ".html", ".htm":
+			t, err = htmltemplate.ParseFiles(path)
+		default:
+			t, err = template.ParseFiles(path)
+		}
+
+		if err != nil {
+			return templates, err
+		}
+
+		templates[name] = t
+	}
+
+	return templates, nil
+}
+
+// templateContentType guesses the content type from the template name,
+// and falls back to plain text.
+func templateContentType(name string) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); len(t) > 0 {
+		return t
+	}
+
+	return "text/plain; charset=utf-8"
+}
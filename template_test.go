@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harboe/gogeo/geo"
+)
+
+var templateTestResults = []geo.Result{
+	{Query: "<b>valby</b>", Address: "Valby", Location: geo.Location{Latitude: 55.66, Longitude: 12.5}},
+	{Query: "aarhus", Address: "Aarhus", Location: geo.Location{Latitude: 56.16, Longitude: 10.2}},
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gogeo")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for name, tmpl := range map[string]string{
+		"sites.csv.tmpl":  "{{.Query}},{{.Latitude}},{{.Longitude}}",
+		"sites.html.tmpl": "<li>{{.Query}}</li>",
+		"list.json":       `{{range $i, $r := .}}{{if $i}},{{end}}"{{$r.Address}}"{{end}}`,
+		".hidden":         "{{",
+	} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(tmpl), 0644))
+	}
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "sub.tmpl"), 0755))
+
+	templates, err := loadTemplates(dir)
+	assert.Nil(t, err)
+	assert.Len(t, templates, 3)
+
+	// each result is rendered on it's own line
+	b, err := templateEncoding{Template: templates["sites.csv"]}.Marshal(&templateTestResults, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "<b>valby</b>,55.66,12.5\naarhus,56.16,10.2\n", string(b))
+
+	// html templates escapes the results
+	b, err = templateEncoding{Template: templates["sites.html"]}.Marshal(&templateTestResults, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "<li>&lt;b&gt;valby&lt;/b&gt;</li>\n<li>aarhus</li>\n", string(b))
+
+	// all renders the template once with the list
+	b, err = templateEncoding{Template: templates["list.json"], All: true}.Marshal(&templateTestResults, false)
+	assert.Nil(t, err)
+	assert.Equal(t, `"Valby","Aarhus"`, string(b))
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "broken.tmpl"), []byte("{{.Query"), 0644))
+	_, err = loadTemplates(dir)
+	assert.NotNil(t, err)
+
+	_, err = loadTemplates(filepath.Join(dir, "missing"))
+	assert.NotNil(t, err)
+}
+
+func TestParseTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gogeo")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "query.tmpl")
+	assert.Nil(t, ioutil.WriteFile(name, []byte("file: {{.Query}}"), 0644))
+
+	for tmpl, expected := range map[string]string{
+		name:           "file: aarhus\n",
+		"{{.Address}}": "Aarhus\n",
+		"inline\n":     "inline\n",
+		"missing.tmpl": "missing.tmpl\n",
+	} {
+		tt, err := parseTemplate(tmpl)
+		assert.Nil(t, err, tmpl)
+
+		b, err := templateEncoding{Template: tt}.Marshal(&[]geo.Result{templateTestResults[1]}, false)
+		assert.Nil(t, err, tmpl)
+		assert.Equal(t, expected, string(b), tmpl)
+	}
+
+	_, err = parseTemplate("{{.Query")
+	assert.NotNil(t, err)
+
+	// execution errors, like an unknown field, are returned
+	tt, _ := parseTemplate("{{.Unknown}}")
+	_, err = templateEncoding{Template: tt}.Marshal(&templateTestResults, false)
+	assert.NotNil(t, err)
+}
+
+func TestTemplateContentType(t *testing.T) {
+	assert.Equal(t, "text/html; charset=utf-8", templateContentType("sites.html"))
+	assert.Equal(t, "application/json", templateContentType("list.json"))
+	assert.Equal(t, "text/plain; charset=utf-8", templateContentType("sites"))
+	assert.Equal(t, "text/plain; charset=utf-8", templateContentType("sites.gogeo-unknown"))
+}